@@ -0,0 +1,153 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AuditEntry is a CORS decision recorded by the AuditLog.
+type AuditEntry struct {
+	// Time is when the decision was made.
+	Time time.Time `json:"time"`
+	// Origin is the value of the "Origin" request header.
+	Origin string `json:"origin"`
+	// Method is the method of the request.
+	Method string `json:"method"`
+	// Path is the URL path of the request.
+	Path string `json:"path"`
+	// ClientIP is the IP address of the client, see Options.TrustedProxies.
+	ClientIP string `json:"client_ip"`
+	// Allowed indicates whether the request was allowed.
+	Allowed bool `json:"allowed"`
+	// Reason names the check that failed, empty for allowed requests.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditLogOptions contains options for the AuditLog.
+type AuditLogOptions struct {
+	// Path is the path of the log file, which is created if it does not exist
+	// and appended to otherwise.
+	Path string
+	// MaxSize is the size in bytes after which the log file is rotated. Default
+	// is 100 MiB.
+	MaxSize int64
+	// MaxBackups is the number of rotated log files to keep, named with suffixes
+	// ".1" (the most recent) to ".<MaxBackups>". Default is 5.
+	MaxBackups int
+	// Allowed set to true also records allowed CORS requests, in addition to
+	// denials. Default is false.
+	Allowed bool
+}
+
+// AuditLog is an append-only log of CORS decisions written as JSON lines, with
+// size-based rotation. It is safe for concurrent use.
+type AuditLog struct {
+	opt AuditLogOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewAuditLog opens the log file and returns a new AuditLog.
+func NewAuditLog(opt AuditLogOptions) (*AuditLog, error) {
+	if opt.Path == "" {
+		return nil, errors.New("empty path")
+	}
+	if opt.MaxSize <= 0 {
+		opt.MaxSize = 100 << 20
+	}
+	if opt.MaxBackups <= 0 {
+		opt.MaxBackups = 5
+	}
+
+	l := &AuditLog{opt: opt}
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.opt.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "stat")
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate shifts the rotated log files by one and starts a new log file.
+func (l *AuditLog) rotate() error {
+	err := l.f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close")
+	}
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", l.opt.Path, l.opt.MaxBackups))
+	for i := l.opt.MaxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", l.opt.Path, i), fmt.Sprintf("%s.%d", l.opt.Path, i+1))
+	}
+	err = os.Rename(l.opt.Path, l.opt.Path+".1")
+	if err != nil {
+		return errors.Wrap(err, "rename")
+	}
+	return l.open()
+}
+
+func (l *AuditLog) record(e AuditEntry) error {
+	p, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	p = append(p, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return errors.New("audit log is closed")
+	}
+	if l.size > 0 && l.size+int64(len(p)) > l.opt.MaxSize {
+		err = l.rotate()
+		if err != nil {
+			return errors.Wrap(err, "rotate")
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "write")
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
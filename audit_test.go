@@ -0,0 +1,121 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func readAuditEntries(t *testing.T, path string) []AuditEntry {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer func() { _ = f.Close() }()
+
+	var entries []AuditEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e AuditEntry
+		assert.Nil(t, json.Unmarshal(s.Bytes(), &e))
+		e.Time = time.Time{}
+		entries = append(entries, e)
+	}
+	assert.Nil(t, s.Err())
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		auditLog, err := NewAuditLog(AuditLogOptions{
+			Path:    path,
+			Allowed: allowed,
+		})
+		assert.Nil(t, err)
+
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{
+			AllowDomain: []string{"example.com"},
+			AuditLog:    auditLog,
+		}))
+		f.Get("/", func(c flamego.Context) string {
+			return responseBody
+		})
+
+		for _, origin := range []string{"http://example.com", "http://evil.com", ""} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.RemoteAddr = "10.0.0.1:1234"
+			// Forwarded addresses of untrusted peers are ignored
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			if origin != "" {
+				req.Header.Set("Origin", origin)
+			}
+			f.ServeHTTP(resp, req)
+		}
+		assert.Nil(t, auditLog.Close())
+
+		want := []AuditEntry{
+			{
+				Origin:   "http://evil.com",
+				Method:   http.MethodGet,
+				Path:     "/",
+				ClientIP: "10.0.0.1",
+				Reason:   "origin host evil.com did not match allowlist entries [example.com]; AllowSubdomain=false",
+			},
+		}
+		if allowed {
+			want = append([]AuditEntry{
+				{
+					Origin:   "http://example.com",
+					Method:   http.MethodGet,
+					Path:     "/",
+					ClientIP: "10.0.0.1",
+					Allowed:  true,
+				},
+			}, want...)
+		}
+		assert.Equal(t, want, readAuditEntries(t, path))
+	}
+}
+
+func TestAuditLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(AuditLogOptions{
+		Path:       path,
+		MaxSize:    1,
+		MaxBackups: 2,
+	})
+	assert.Nil(t, err)
+
+	for _, origin := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, auditLog.record(AuditEntry{Origin: origin}))
+	}
+	assert.Nil(t, auditLog.Close())
+
+	// Every entry exceeds the max size, thus is written to a new file
+	for suffix, origin := range map[string]string{"": "d", ".1": "c", ".2": "b"} {
+		entries := readAuditEntries(t, path+suffix)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, origin, entries[0].Origin)
+		}
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.EqualError(t, auditLog.record(AuditEntry{}), "audit log is closed")
+}
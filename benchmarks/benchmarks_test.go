@@ -0,0 +1,162 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package benchmarks compares the CORS middleware with popular alternatives
+// under equivalent configurations.
+package benchmarks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gincors "github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	rscors "github.com/rs/cors"
+
+	"github.com/flamego/cors"
+	"github.com/flamego/flamego"
+)
+
+// config is a CORS configuration that is expressed equivalently for every
+// middleware.
+type config struct {
+	allowOrigins     []string // Empty for any origin
+	allowCredentials bool
+}
+
+var (
+	allowMethods = []string{http.MethodGet, http.MethodOptions, http.MethodPost}
+	allowHeaders = []string{"Content-Type"}
+	maxAge       = 10 * time.Minute
+)
+
+func newFlamego(c config) http.Handler {
+	opts := cors.Options{
+		Scheme:           "https",
+		Methods:          allowMethods,
+		AllowHeaders:     allowHeaders,
+		MaxAge:           maxAge,
+		AllowCredentials: c.allowCredentials,
+	}
+	for _, o := range c.allowOrigins {
+		opts.AllowDomain = append(opts.AllowDomain, o[len("https://"):])
+	}
+
+	f := flamego.NewWithLogger(io.Discard)
+	f.Use(cors.CORS(opts))
+	f.Get("/", func(c flamego.Context) {
+		c.ResponseWriter().WriteHeader(http.StatusNoContent)
+	})
+	return f
+}
+
+func newRSCORS(c config) http.Handler {
+	return rscors.New(rscors.Options{
+		AllowedOrigins:   c.allowOrigins,
+		AllowedMethods:   allowMethods,
+		AllowedHeaders:   allowHeaders,
+		MaxAge:           int(maxAge.Seconds()),
+		AllowCredentials: c.allowCredentials,
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func newGinContrib(c config) http.Handler {
+	gin.SetMode(gin.ReleaseMode)
+	conf := gincors.Config{
+		AllowOrigins:     c.allowOrigins,
+		AllowAllOrigins:  len(c.allowOrigins) == 0,
+		AllowMethods:     allowMethods,
+		AllowHeaders:     allowHeaders,
+		MaxAge:           maxAge,
+		AllowCredentials: c.allowCredentials,
+	}
+
+	e := gin.New()
+	e.Use(gincors.New(conf))
+	e.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return e
+}
+
+func BenchmarkCORS(b *testing.B) {
+	middlewares := []struct {
+		name string
+		new  func(c config) http.Handler
+	}{
+		{name: "flamego", new: newFlamego},
+		{name: "rs", new: newRSCORS},
+		{name: "gin-contrib", new: newGinContrib},
+	}
+	benchmarks := []struct {
+		name    string
+		config  config
+		method  string
+		headers map[string]string
+	}{
+		{
+			name:   "wildcard",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+		},
+		{
+			name: "allowlist",
+			config: config{
+				allowOrigins:     []string{"https://example.com"},
+				allowCredentials: true,
+			},
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+		},
+		{
+			name: "preflight",
+			config: config{
+				allowOrigins: []string{"https://example.com"},
+			},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://example.com",
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "content-type",
+			},
+		},
+	}
+	for _, bm := range benchmarks {
+		for _, m := range middlewares {
+			b.Run(bm.name+"/"+m.name, func(b *testing.B) {
+				h := m.new(bm.config)
+
+				req, err := http.NewRequest(bm.method, "/", nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for k, v := range bm.headers {
+					req.Header.Set(k, v)
+				}
+
+				// Make sure the configurations are indeed equivalent
+				resp := httptest.NewRecorder()
+				h.ServeHTTP(resp, req)
+				if resp.Header().Get("Access-Control-Allow-Origin") == "" {
+					b.Fatalf("not allowed: %d %v", resp.Code, resp.Header())
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					h.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		}
+	}
+}
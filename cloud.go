@@ -0,0 +1,194 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cloudRule is a CORS rule of a cloud storage bucket, in the terms of the
+// middleware.
+type cloudRule struct {
+	origins      []string
+	methods      []string
+	allowHeaders []string
+	expose       []string
+	maxAge       int
+}
+
+// s3Rule is a CORS rule of an AWS S3 bucket.
+type s3Rule struct {
+	ID             string   `json:"ID"`
+	AllowedOrigins []string `json:"AllowedOrigins"`
+	AllowedMethods []string `json:"AllowedMethods"`
+	AllowedHeaders []string `json:"AllowedHeaders"`
+	ExposeHeaders  []string `json:"ExposeHeaders"`
+	MaxAgeSeconds  int      `json:"MaxAgeSeconds"`
+}
+
+// FromS3Config translates the CORS configuration of an AWS S3 bucket to
+// options, easing migrations from bucket-hosted APIs. Both the document of
+// "aws s3api put-bucket-cors" (an object with "CORSRules") and the bare list
+// of rules of the console are accepted. Rules may only differ in their
+// origins, and origins may only have wildcards as the single "*" or as whole
+// labels, e.g. "https://*.example.com".
+func FromS3Config(data []byte) (Options, error) {
+	var rules []s3Rule
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &rules)
+		if err != nil {
+			return Options{}, errors.Wrap(err, "unmarshal rules")
+		}
+	} else {
+		var config struct {
+			CORSRules []s3Rule `json:"CORSRules"`
+		}
+		err := json.Unmarshal(data, &config)
+		if err != nil {
+			return Options{}, errors.Wrap(err, "unmarshal config")
+		}
+		rules = config.CORSRules
+	}
+
+	cloudRules := make([]cloudRule, 0, len(rules))
+	for _, r := range rules {
+		allowHeaders := r.AllowedHeaders
+		if contains(allowHeaders, "*") {
+			allowHeaders = nil
+		}
+		cloudRules = append(cloudRules, cloudRule{
+			origins:      r.AllowedOrigins,
+			methods:      r.AllowedMethods,
+			allowHeaders: allowHeaders,
+			expose:       r.ExposeHeaders,
+			maxAge:       r.MaxAgeSeconds,
+		})
+	}
+	opt, err := fromCloudRules(cloudRules)
+	if err != nil {
+		return Options{}, err
+	}
+	// S3 allows credentials for every origin that is not the wildcard.
+	opt.AllowCredentials = !allowAnyDomain(opt)
+	return opt, nil
+}
+
+// gcsRule is a CORS rule of a Google Cloud Storage bucket.
+type gcsRule struct {
+	Origin         []string `json:"origin"`
+	Method         []string `json:"method"`
+	ResponseHeader []string `json:"responseHeader"`
+	MaxAgeSeconds  int      `json:"maxAgeSeconds"`
+}
+
+// FromGCSConfig translates the CORS configuration of a Google Cloud Storage
+// bucket, as used by "gsutil cors set", to options, easing migrations from
+// bucket-hosted APIs. Rules may only differ in their origins, and origins may
+// only have wildcards as the single "*" or as whole labels, e.g.
+// "https://*.example.com".
+func FromGCSConfig(data []byte) (Options, error) {
+	var rules []gcsRule
+	err := json.Unmarshal(data, &rules)
+	if err != nil {
+		return Options{}, errors.Wrap(err, "unmarshal rules")
+	}
+
+	cloudRules := make([]cloudRule, 0, len(rules))
+	for _, r := range rules {
+		// GCS allows the response headers as request headers as well.
+		cloudRules = append(cloudRules, cloudRule{
+			origins:      r.Origin,
+			methods:      r.Method,
+			allowHeaders: r.ResponseHeader,
+			expose:       r.ResponseHeader,
+			maxAge:       r.MaxAgeSeconds,
+		})
+	}
+	return fromCloudRules(cloudRules)
+}
+
+// fromCloudRules returns the options of the rules, which may only differ in
+// their origins.
+func fromCloudRules(rules []cloudRule) (Options, error) {
+	if len(rules) == 0 {
+		return Options{}, errors.New("no rules")
+	}
+
+	first := rules[0]
+	for i, r := range rules[1:] {
+		if !equalFold(r.methods, first.methods) ||
+			!equalFold(r.allowHeaders, first.allowHeaders) ||
+			!equalFold(r.expose, first.expose) ||
+			r.maxAge != first.maxAge {
+			return Options{}, errors.Errorf("rule %d differs from the first rule in more than origins", i+1)
+		}
+	}
+
+	opt := Options{
+		Methods:       make([]string, 0, len(first.methods)),
+		AllowHeaders:  cloneStrings(first.allowHeaders),
+		ExposeHeaders: cloneStrings(first.expose),
+		MaxAge:        time.Duration(first.maxAge) * time.Second,
+	}
+	for _, m := range first.methods {
+		opt.Methods = append(opt.Methods, strings.ToUpper(m))
+	}
+
+	for _, r := range rules {
+		for _, o := range r.origins {
+			if o == "*" {
+				opt.AllowDomain = []string{"*"}
+				opt.SchemeDomains = nil
+				return opt, nil
+			}
+
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return Options{}, errors.Errorf("invalid origin %q", o)
+			}
+			if strings.Contains(o, "*") && !wildcardHost(u.Host) {
+				return Options{}, errors.Errorf("wildcard origin %q is not supported", o)
+			}
+			if opt.SchemeDomains == nil {
+				opt.SchemeDomains = make(map[string][]string)
+			}
+			if !contains(opt.SchemeDomains[u.Scheme], u.Host) {
+				opt.SchemeDomains[u.Scheme] = append(opt.SchemeDomains[u.Scheme], u.Host)
+			}
+		}
+	}
+	return opt, nil
+}
+
+// wildcardHost returns true if every "*" of the host is a whole label, e.g.
+// "*.example.com", which AllowDomain matches as a pattern.
+func wildcardHost(host string) bool {
+	for _, l := range strings.Split(host, ".") {
+		if l != "*" && strings.Contains(l, "*") {
+			return false
+		}
+	}
+	return true
+}
+
+// equalFold returns true if the lists contain the same values in the same
+// order, ignoring case.
+func equalFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
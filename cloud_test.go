@@ -0,0 +1,141 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromS3Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Options
+		wantErr string
+	}{
+		{
+			name: "put-bucket-cors",
+			data: `{
+  "CORSRules": [
+    {
+      "AllowedOrigins": ["https://example.com", "http://localhost:3000"],
+      "AllowedMethods": ["GET", "PUT"],
+      "AllowedHeaders": ["Authorization"],
+      "ExposeHeaders": ["ETag"],
+      "MaxAgeSeconds": 3000
+    },
+    {
+      "AllowedOrigins": ["https://app.example.com"],
+      "AllowedMethods": ["GET", "PUT"],
+      "AllowedHeaders": ["Authorization"],
+      "ExposeHeaders": ["ETag"],
+      "MaxAgeSeconds": 3000
+    }
+  ]
+}`,
+			want: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com", "app.example.com"},
+					"http":  {"localhost:3000"},
+				},
+				Methods:          []string{"GET", "PUT"},
+				AllowHeaders:     []string{"Authorization"},
+				ExposeHeaders:    []string{"ETag"},
+				MaxAge:           3000 * time.Second,
+				AllowCredentials: true,
+			},
+		},
+		{
+			name: "console",
+			data: `[{"AllowedOrigins": ["*"], "AllowedMethods": ["GET"], "AllowedHeaders": ["*"]}]`,
+			want: Options{
+				AllowDomain: []string{"*"},
+				Methods:     []string{"GET"},
+			},
+		},
+		{
+			name: "wildcard subdomain",
+			data: `[{"AllowedOrigins": ["https://*.example.com"], "AllowedMethods": ["GET"]}]`,
+			want: Options{
+				SchemeDomains:    map[string][]string{"https": {"*.example.com"}},
+				Methods:          []string{"GET"},
+				AllowCredentials: true,
+			},
+		},
+		{
+			name:    "partial wildcard",
+			data:    `[{"AllowedOrigins": ["https://api-*.example.com"], "AllowedMethods": ["GET"]}]`,
+			wantErr: `wildcard origin "https://api-*.example.com" is not supported`,
+		},
+		{
+			name:    "different rules",
+			data:    `[{"AllowedOrigins": ["https://example.com"], "AllowedMethods": ["GET"]}, {"AllowedOrigins": ["https://example.org"], "AllowedMethods": ["PUT"]}]`,
+			wantErr: "rule 1 differs from the first rule in more than origins",
+		},
+		{
+			name:    "no rules",
+			data:    `{"CORSRules": []}`,
+			wantErr: "no rules",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := FromS3Config([]byte(test.data))
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFromGCSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Options
+		wantErr string
+	}{
+		{
+			name: "origins",
+			data: `[{"origin": ["https://example.com"], "method": ["get", "head"], "responseHeader": ["Content-Type"], "maxAgeSeconds": 3600}]`,
+			want: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com"},
+				},
+				Methods:       []string{"GET", "HEAD"},
+				AllowHeaders:  []string{"Content-Type"},
+				ExposeHeaders: []string{"Content-Type"},
+				MaxAge:        time.Hour,
+			},
+		},
+		{
+			name:    "invalid origin",
+			data:    `[{"origin": ["example.com"], "method": ["GET"]}]`,
+			wantErr: `invalid origin "example.com"`,
+		},
+		{
+			name:    "invalid JSON",
+			data:    `{}`,
+			wantErr: "unmarshal rules: json: cannot unmarshal object into Go value of type []cors.gcsRule",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := FromGCSConfig([]byte(test.data))
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/cors"
+	"github.com/flamego/cors/corstest"
+	"github.com/flamego/flamego"
+)
+
+// TestConformance runs a curated subset of the CORS cases of web-platform-tests
+// (https://github.com/web-platform-tests/wpt/tree/master/cors), translated to
+// the checks a browser performs on the responses. Cases that the middleware
+// does not pass are skipped with the reason, so the list documents the extent
+// of the Fetch spec compliance.
+func TestConformance(t *testing.T) {
+	route := corstest.Route{
+		Method: "*",
+		Path:   "/resource",
+		Handler: func(c flamego.Context) string {
+			c.ResponseWriter().Header().Set("X-Custom", "1")
+			return "ok"
+		},
+	}
+
+	tests := []struct {
+		name    string
+		wpt     string
+		options cors.Options
+		origin  string
+		method  string
+		skip    string
+		check   func(t *testing.T, got *corstest.Result)
+	}{
+		{
+			name:   "simple request with wildcard",
+			wpt:    "cors/basic.htm",
+			origin: "https://example.com",
+			method: http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.True(t, got.Allowed)
+				assert.Equal(t, "ok", got.Response.Body)
+			},
+		},
+		{
+			name: "allowed origin is echoed exactly",
+			wpt:  "cors/origin.htm",
+			options: cors.Options{
+				Scheme:      "https",
+				AllowDomain: []string{"example.com"},
+			},
+			origin: "https://example.com",
+			method: http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.True(t, got.Allowed)
+				assert.Equal(t, "https://example.com", got.Response.Header.Get("Access-Control-Allow-Origin"))
+				assert.Contains(t, got.Response.Header.Values("Vary"), "Origin")
+			},
+		},
+		{
+			name: "origin with a different port is not allowed",
+			wpt:  "cors/origin.htm",
+			options: cors.Options{
+				Scheme:      "https",
+				AllowDomain: []string{"example.com"},
+			},
+			origin: "https://example.com:8443",
+			method: http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.False(t, got.Allowed)
+			},
+		},
+		{
+			name: "opaque null origin is not allowed",
+			wpt:  "cors/origin.htm",
+			options: cors.Options{
+				AllowDomain: []string{"example.com"},
+			},
+			origin: "null",
+			method: http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.False(t, got.Allowed)
+			},
+		},
+		{
+			name: "preflight for an allowed method",
+			wpt:  "cors/preflight-cache.htm",
+			options: cors.Options{
+				Methods: []string{http.MethodGet, http.MethodPut},
+			},
+			origin: "https://example.com",
+			method: http.MethodPut,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.True(t, got.PreflightAllowed)
+				assert.True(t, got.Allowed)
+				assert.Equal(t, "600", got.Preflight.Header.Get("Access-Control-Max-Age"))
+			},
+		},
+		{
+			name: "preflight for a method that is not allowed",
+			wpt:  "cors/preflight-failure.htm",
+			options: cors.Options{
+				Methods: []string{http.MethodGet},
+			},
+			origin: "https://example.com",
+			method: http.MethodDelete,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.False(t, got.PreflightAllowed)
+				assert.Nil(t, got.Response)
+			},
+		},
+		{
+			name: "preflight from a prohibited origin",
+			wpt:  "cors/preflight-failure.htm",
+			options: cors.Options{
+				AllowDomain: []string{"example.com"},
+			},
+			origin: "http://evil.com",
+			method: http.MethodPut,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.False(t, got.PreflightAllowed)
+				assert.Nil(t, got.Response)
+			},
+		},
+		{
+			name: "credentialed response is never a wildcard",
+			wpt:  "cors/credentials-flag.htm",
+			options: cors.Options{
+				AllowDomain:      []string{"!*"},
+				AllowCredentials: true,
+			},
+			origin: "http://example.com",
+			method: http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.True(t, got.Allowed)
+				assert.Equal(t, "http://example.com", got.Response.Header.Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true", got.Response.Header.Get("Access-Control-Allow-Credentials"))
+			},
+		},
+		{
+			name:    "non-safelisted response headers are exposed when listed",
+			wpt:     "cors/response-headers.htm",
+			options: cors.Options{ExposeHeaders: []string{"X-Custom"}},
+			origin:  "https://example.com",
+			method:  http.MethodGet,
+			check: func(t *testing.T, got *corstest.Result) {
+				assert.True(t, got.Allowed)
+				assert.Equal(t, "X-Custom", got.Response.Header.Get("Access-Control-Expose-Headers"))
+				assert.Equal(t, "1", got.Response.Header.Get("X-Custom"))
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.skip != "" {
+				t.Skipf("%s: %s", test.wpt, test.skip)
+			}
+
+			s := corstest.NewServer(test.options, route)
+			defer s.Close()
+
+			got, err := s.Prober().PreflightThenRequest(test.origin, test.method, "/resource")
+			assert.Nil(t, err)
+			test.check(t, got)
+		})
+	}
+}
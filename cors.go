@@ -6,13 +6,24 @@
 package cors
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime/pprof"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/log"
+
+	"github.com/flamego/cors/policy"
 	"github.com/flamego/flamego"
 )
 
@@ -21,25 +32,456 @@ type Options struct {
 	// Scheme may be http or https as accepted schemes or the "*" wildcard to accept
 	// any scheme. Default is "http".
 	Scheme string
+	// Schemes is the list of accepted schemes, e.g. ["https", "capacitor"] for
+	// a hybrid mobile and web app, without opening up every scheme with "*".
+	// When set, Scheme is ignored, origins with other schemes are denied and
+	// the requesting origin is replied with its own scheme, while the "*"
+	// wildcard of AllowDomain still replies with "*" and thus never allows
+	// credentials. It is ignored when SchemeDomains is set. Default is nil.
+	Schemes []string
 	// AllowDomain is a comma separated list of domains that are allowed to initiate
 	// CORS requests. Special value is a single "*" wildcard that will allow any
 	// domain to send requests without credentials and the special "!*" wildcard
 	// which will reply with requesting domain in the "access-control-allow-origin"
-	// header and hence allow requests from any domain *with* credentials. Default
-	// is "*".
+	// header and hence allow requests from any domain *with* credentials. A
+	// domain may end with a port wildcard (e.g. "localhost:*") or an inclusive
+	// port range (e.g. "127.0.0.1:3000-3999") for dev tooling that assigns ports
+	// dynamically. Subdomains may be allowed per domain with "*" labels, where a
+	// leading "*" matches one or more labels (e.g. "*.example.com") and any
+	// other matches exactly one (e.g. "api.*.example.com"). Default is "*".
 	AllowDomain []string
+	// AllowOriginPatterns is the list of regular expressions that must match
+	// the full origin, in addition to AllowDomain, e.g.
+	// `https://pr-\d+\.preview\.example\.com` for per-PR preview
+	// environments. Patterns are anchored at both ends implicitly, compiled
+	// once, and the middleware panics on invalid ones. Allowed origins are
+	// reflected as sent, and are not published in manifests, policy files and
+	// CSPConnectSrc. Default is nil.
+	AllowOriginPatterns []string
+	// AllowOriginFunc reports whether the origin is allowed, with the context of
+	// the request, e.g. by looking it up in a database of registered customer
+	// domains. When set, it is consulted instead of AllowDomain,
+	// SchemeDomains, Canary and FlagDomains, and allowed origins are reflected
+	// as sent. Dynamic origins are not published in manifests, policy files and
+	// CSPConnectSrc. Default is nil.
+	AllowOriginFunc func(ctx context.Context, origin string) bool
+	// TXTAllowlist allows the origins of a DNS TXT record, which is looked up
+	// again once its TTL has passed. Like AllowOriginFunc, it is consulted
+	// instead of AllowDomain, SchemeDomains, Canary and FlagDomains, and an
+	// origin is allowed if either of them allows it. Default is nil.
+	TXTAllowlist *TXTAllowlist
 	// AllowSubdomain allowed subdomains of domains to run CORS requests. Default is
 	// false.
 	AllowSubdomain bool
+	// SchemeDomains maps a scheme to the list of domains that are allowed to
+	// initiate CORS requests over that scheme, e.g. production domains for "https"
+	// and "localhost:3000" for "http". Entries follow the same rules as
+	// AllowDomain. When set, Scheme and AllowDomain are ignored and the requesting
+	// origin is replied with its own scheme. Default is nil.
+	SchemeDomains map[string][]string
 	// Methods may be a comma separated list of HTTP-methods to be accepted. Default
 	// is ["GET", "POST", "OPTIONS"].
 	Methods []string
+	// AppendMethods is the list of HTTP-methods to be accepted in addition to
+	// Methods or its default, e.g. ["PUT", "PATCH", "DELETE"]. Default is nil.
+	AppendMethods []string
+	// AllowHeaders is the list of request headers that are allowed in addition
+	// to SafelistedHeaders, e.g. ["Authorization"]. Preflight requests asking
+	// for other headers are denied. When not set, the requested headers are
+	// reflected. Default is nil.
+	AllowHeaders []string
+	// ReplaceAllowHeaders set to true allows only AllowHeaders without the
+	// SafelistedHeaders baseline. Default is false.
+	ReplaceAllowHeaders bool
+	// AllowLegacyXRequestedWith set to true allows the "X-Requested-With"
+	// header that older AJAX libraries and mobile SDKs send, in addition to
+	// AllowHeaders. It has no effect when the requested headers are reflected.
+	// Servers that treat the header as proof of a same-origin request, as an
+	// anti-CSRF check, lose that guarantee for allowed origins. Default is
+	// false.
+	AllowLegacyXRequestedWith bool
 	// MaxAgeSeconds may be the duration in secs for which the response is cached.
 	// Default is 600 * time.Second.
 	MaxAge time.Duration
+	// OptionsPassthrough set to true lets OPTIONS requests, including
+	// preflight requests, continue down the handler chain after the CORS
+	// headers are set instead of being answered by the middleware, for apps
+	// that register their own OPTIONS routes, e.g. WebDAV-style APIs. Denied
+	// preflight requests are still rejected. Default is false.
+	OptionsPassthrough bool
+	// PreflightStatus is the status code of the responses to OPTIONS requests
+	// that are answered by the middleware, which is written without a body. It
+	// must be a 2xx status code, as browsers reject other preflight responses.
+	// Default is 204 (http.StatusNoContent).
+	PreflightStatus int
 	// AllowCredentials set to false rejects any request with credentials. Default
 	// is false.
 	AllowCredentials bool
+	// PrivateCache set to true marks every response that is served with
+	// "Access-Control-Allow-Credentials: true" as "Cache-Control: private",
+	// replacing "public" and dropping "s-maxage" from any value set by the
+	// handler, so that shared caches never store per-user cross-origin
+	// responses. Responses with "no-store" are left as is. Default is false.
+	PrivateCache bool
+	// RequireSecureOrigin set to true rejects any request from a non-HTTPS origin,
+	// even if the domain is allowed. Default is false.
+	RequireSecureOrigin bool
+	// AllowInsecureLocalhost set to true exempts loopback origins (e.g.
+	// "http://localhost:3000") from RequireSecureOrigin. Default is false.
+	AllowInsecureLocalhost bool
+	// OriginAgentCluster set to true emits the "Origin-Agent-Cluster: ?1" header
+	// on every response. Default is false.
+	OriginAgentCluster bool
+	// PermittedCrossDomainPolicies is the value of the
+	// "X-Permitted-Cross-Domain-Policies" header emitted on every response, e.g.
+	// "none" to forbid legacy Flash and PDF clients from loading cross-domain
+	// policy files. Default is empty, which emits no header.
+	PermittedCrossDomainPolicies string
+	// ContentSecurityPolicy set to true emits a "Content-Security-Policy" header
+	// with the "connect-src" directive returned by CSPConnectSrc on every
+	// response, unless a subsequent handler replaces it. Default is false.
+	ContentSecurityPolicy bool
+	// CheckReferer set to true rejects any request whose "Referer" header does not
+	// agree with its "Origin" header on the scheme and host, when both are
+	// present. Default is false.
+	CheckReferer bool
+	// StrictDefaults set to true opts into hardened defaults: no domain is allowed
+	// unless configured, Methods defaults to ["GET", "HEAD", "POST"], preflight
+	// headers are only sent on preflight responses, and SilentReject is
+	// implied. Default is false.
+	StrictDefaults bool
+	// SilentReject set to true lets prohibited requests through without CORS
+	// headers instead of rejecting them with an error, as servers commonly do
+	// per the Fetch standard, so that browsers block the responses. Prohibited
+	// preflight requests are answered without CORS headers. Default is false.
+	SilentReject bool
+	// AllowFetchDest is the list of request destinations, as sent by browsers in
+	// the "Sec-Fetch-Dest" header, that are allowed, e.g. ["empty"] to allow
+	// fetch and XHR from allowed origins but deny being loaded as a document,
+	// iframe or script. It applies to every request that has the header,
+	// including non-CORS ones, and the header is added to "Vary". Default is nil
+	// (any destination).
+	AllowFetchDest []string
+	// VaryHeaders is the list of additional request headers that the policy
+	// decision depends on, e.g. a tenant header consulted by a Listener or
+	// AllowClientIP hook, which are added to the "Vary" header of every response
+	// to keep intermediary caches correct. Entries set by earlier handlers, e.g.
+	// "Accept-Encoding", are kept. Default is nil.
+	VaryHeaders []string
+	// DecorateStatus is the list of response status codes that receive CORS
+	// headers, e.g. [200, 201, 204, 400, 422] to leave other responses
+	// undecorated so cross-origin scripts cannot read them. Default is nil (every
+	// status code). Preflight responses are always decorated. The status code is
+	// only known to the middleware when the response is written through the
+	// injected http.ResponseWriter or returned from the handler, not through
+	// Context.ResponseWriter directly.
+	DecorateStatus []int
+	// SkipStatus is the list of response status codes that never receive CORS
+	// headers, e.g. [401, 403], which takes precedence over DecorateStatus. The
+	// same limitation as DecorateStatus applies. Default is nil.
+	SkipStatus []int
+	// ExemptPaths is the list of request path patterns, in the syntax of
+	// path.Match (e.g. "/internal/*"), that the middleware leaves alone: their
+	// responses get no CORS headers and their preflight requests are passed to
+	// the routes instead of being answered. Use Exempt for the actual responses
+	// of routes nearer to their code. Default is nil.
+	ExemptPaths []string
+	// CDNSafe set to true tunes the middleware for CDN-fronted APIs, so that
+	// responses to one origin are never served to another from a shared cache:
+	// "Vary: Origin" is sent on every response, including wildcard, non-CORS
+	// and denied ones, and requested headers are never reflected in the
+	// "Access-Control-Allow-Headers" header, which lists SafelistedHeaders and
+	// AllowHeaders instead in a stable order. Use MaxAge to control how long
+	// preflight responses are cached. Default is false.
+	CDNSafe bool
+	// ProblemDetails set to true responds to denied requests with
+	// "application/problem+json" bodies (RFC 7807) instead of plain text, whose
+	// "type" is ProblemTypePrefix followed by the code of the denial reason.
+	// Default is false.
+	ProblemDetails bool
+	// DebugErrorPage set to true responds to denied requests that accept
+	// "text/html" with an error page naming the failed check and how to fix it,
+	// and adds both to Problem Details, to debug the policy from a browser. It
+	// exposes the allowlist to any client, so it must not be set in production.
+	// Default is false.
+	DebugErrorPage bool
+	// RejectStatus is the status code of the responses to denied requests,
+	// whatever the reason, e.g. 403 (http.StatusForbidden) to comply with a
+	// security policy. It must be a 4xx status code. Default is 400
+	// (http.StatusBadRequest).
+	RejectStatus int
+	// ErrorHandler responds to denied requests when set, instead of the
+	// plain-text, Problem Details or error page response, e.g. to return the
+	// standard JSON error envelope of an API and log through its own logger.
+	// The reason is a *DeniedError, and the handler is responsible for writing
+	// the response, usually with RejectStatus. It is not called with
+	// SilentReject, which lets denied requests through. Default is nil.
+	ErrorHandler func(ctx flamego.Context, reason error)
+	// DenialLog records denied requests when set, see NewDenialLog. Default is
+	// nil.
+	DenialLog *DenialLog
+	// Diagnose set to true logs a warning with the probable fix for every
+	// response to a cross-origin request that browsers will block, e.g. without
+	// "Access-Control-Allow-Origin" or with credentials not allowed, catching
+	// silent breakage that otherwise only shows up in user consoles. Denied
+	// requests are logged with their reasons as in development mode. It is meant
+	// for development and staging. Default is false.
+	Diagnose bool
+	// ProfilerLabels set to true runs the evaluation of every request with pprof
+	// labels "middleware=cors" and "preflight=true|false", so CPU profiles show
+	// the time spent by the middleware. Default is false.
+	ProfilerLabels bool
+	// Recorder records every CORS request and its decision when set, see
+	// NewRecorder and Replay. Default is nil.
+	Recorder *Recorder
+	// ExposeHeaders is the list of response headers (e.g. "X-Total-Count" and
+	// "ETag") that are exposed to cross-origin scripts through the
+	// "Access-Control-Expose-Headers" header of actual responses, on top of the
+	// CORS-safelisted response headers. The "*" wildcard exposes all headers of
+	// requests without credentials. Default is nil.
+	ExposeHeaders []string
+	// ExposeTrailers is the list of trailer names (e.g. "Grpc-Status") of
+	// streaming responses that are exposed to cross-origin scripts through the
+	// "Access-Control-Expose-Headers" header of actual responses. Handlers still
+	// announce the trailers with the "Trailer" header as usual. Default is nil.
+	ExposeTrailers []string
+	// TimingAllowOrigin is the list of origins (e.g. "https://example.com") that
+	// may read the full Resource Timing data of actual responses through the
+	// "Timing-Allow-Origin" header, which is sent alongside the CORS headers of
+	// allowed requests. The "*" wildcard allows any origin, otherwise the origin
+	// of the request is reflected when it is listed. Default is nil.
+	TimingAllowOrigin []string
+	// AllowSameHost set to true allows origins on the same host as the server
+	// with any port, e.g. "http://localhost:3000" talking to the server at
+	// "localhost:8080", without listing every port in AllowDomain. Every port of
+	// the host is allowed whatever the environment, so it is meant for local
+	// development only. Default is false.
+	AllowSameHost bool
+	// AlwaysAllowOrigin set to true sends the "Access-Control-Allow-Origin"
+	// header on every allowed response, for CDN and proxy validations that
+	// expect it regardless of the request being cross-origin: requests without
+	// the "Origin" header are answered with the origin of the server itself,
+	// and same-origin requests (e.g. a form POST of the server's own page) are
+	// allowed with their origin even when the server host is not in the
+	// allowlist. Default is false.
+	AlwaysAllowOrigin bool
+	// AllowClientIP is called for every CORS request that is allowed by the
+	// policy with the origin and the client IP, which is nil when it cannot be
+	// parsed, e.g. to only accept internal origins from the corporate network.
+	// Returning false denies the request. The client IP is the address of the
+	// direct peer, or the one forwarded in the "X-Real-IP" or "X-Forwarded-For"
+	// header by TrustedProxies. Default is nil.
+	AllowClientIP func(ctx context.Context, origin string, ip net.IP) bool
+	// AllowClientCertificate is called for every CORS request from a prohibited
+	// domain that presented a verified TLS client certificate, with the origin
+	// and the connection state. Returning true allows the request by reflecting
+	// its origin, e.g. to grant broader origin allowances to internal dashboards
+	// authenticated with mutual TLS. Default is nil.
+	AllowClientCertificate func(ctx context.Context, origin string, state *tls.ConnectionState) bool
+	// AuditLog records every denial, and every allowed CORS request if
+	// configured, when set, see NewAuditLog. Default is nil.
+	AuditLog *AuditLog
+	// Expires maps an entry of AllowDomain or SchemeDomains to the time after
+	// which it is automatically denied, e.g. for time-boxed partner integrations
+	// and pentest engagements. Default is nil.
+	Expires map[string]time.Time
+	// Windows maps an entry of AllowDomain or SchemeDomains to the periods of
+	// time during which it is allowed, e.g. for a partner migration weekend, so
+	// temporary access turns itself off without a follow-up deploy. Default is
+	// nil.
+	Windows map[string][]policy.Window
+	// SunsetNotice is how long before the expiry of an entry in Expires the
+	// "Deprecation" and "Sunset" headers are emitted on responses to its origins,
+	// so that the partner's developers get machine-readable advance notice
+	// before access is cut. Default is 0 (disabled).
+	SunsetNotice time.Duration
+	// OnSunset is called for every response that is emitted with a "Sunset"
+	// header due to SunsetNotice, with the origin and the expiry of its entry.
+	// Default is nil.
+	OnSunset func(ctx context.Context, origin string, sunset time.Time)
+	// Quota is the maximum number of allowed CORS requests of each allowlist
+	// entry in every QuotaWindow, further requests are rejected with "429 Too
+	// Many Requests" until the window resets, so a misbehaving partner origin
+	// can't consume the whole API. Requests are counted by the entry that
+	// allowed them (see Decision.Rule), or by client IP when the rule allows
+	// origins chosen by the client, e.g. "*". It only limits browser traffic:
+	// requests without an origin are not counted, so it is not a rate limit
+	// against clients that omit the header. Default is 0 (unlimited).
+	Quota int
+	// QuotaWindow is the length of the windows of Quota. Default is 1 minute.
+	QuotaWindow time.Duration
+	// QuotaStore counts the requests of quota keys for Quota. Default is an
+	// in-memory store of each middleware, see NewMemoryQuotaStore.
+	QuotaStore QuotaStore
+	// OriginHeader is the request header that carries the origin of the browser
+	// when a proxy in front of the server strips or rewrites the "Origin"
+	// header, e.g. "X-Original-Origin". It is only honored on requests from
+	// TrustedProxies, others are evaluated by their "Origin" header, and it is
+	// added to the "Vary" header. Default is "" ("Origin").
+	OriginHeader string
+	// TrustedProxies is the list of IP addresses and CIDR ranges (e.g.
+	// "10.0.0.0/8") of the proxies that are trusted to set OriginHeader and to
+	// forward the client IP, matched against the address of the direct peer. It
+	// must be set to use OriginHeader. Default is nil.
+	TrustedProxies []string
+	// NormalizeOrigin is applied to the "Origin" request header before it is
+	// matched, e.g. to strip vanity subdomains or map legacy hostnames, and
+	// returning an error rejects the request as an invalid origin. An allowed
+	// request is still answered with the origin as sent by the browser. Default
+	// is nil.
+	NormalizeOrigin func(ctx context.Context, raw string) (string, error)
+	// Messages maps the code of a denial reason (e.g.
+	// policy.CodeProhibitedDomain) to the message that is returned to the client
+	// and written to logs instead of the built-in English message, where
+	// "{value}" is replaced by the offending value, e.g. the origin. Default is
+	// nil.
+	Messages map[string]string
+	// RedactOrigin set to true replaces the origin and other offending values
+	// with "[redacted]" in client-visible denial messages and omits the origin
+	// from Problem Details, as well as the reasons and hints that are otherwise
+	// sent with DebugErrorPage, while logs, DenialLog and AuditLog keep the raw
+	// values. Values are always escaped and truncated otherwise, see
+	// policy.Sanitize. Default is false.
+	RedactOrigin bool
+	// Canary maps a newly-added domain to the percentage (0 to 100) of its
+	// requests that are allowed while the rollout is monitored, other requests
+	// are denied as if the domain was not allowed. Canary domains are not
+	// included in policy files and CSPConnectSrc until promoted to AllowDomain.
+	// Default is nil.
+	Canary map[string]int
+	// Rand returns a non-negative pseudo-random number less than n for Canary,
+	// e.g. a seeded source to make rollouts deterministic in tests. It must be
+	// safe for concurrent use. Default is rand.Intn.
+	Rand func(n int) int
+	// Clock returns the current time for Expires, Windows, SunsetNotice, quotas
+	// and logs, e.g. a fixed time to test scheduled changes. Default is
+	// time.Now.
+	Clock func() time.Time
+	// Flags is the feature flag provider that is consulted for Flag and
+	// FlagDomains. Default is nil.
+	Flags FlagProvider
+	// Flag is the name of the flag that must be enabled for the middleware to
+	// apply, requests pass through untouched while it is disabled. Default is
+	// empty, which always applies the middleware.
+	Flag string
+	// FlagDomains maps a domain to the name of the flag that must be enabled for
+	// the domain to be allowed in addition to AllowDomain, so origins can be
+	// toggled without redeploys. Default is nil.
+	FlagDomains map[string]string
+	// Shadow is a candidate policy that is evaluated alongside for comparison
+	// only, see Shadow. Default is nil.
+	Shadow *Shadow
+	// Listeners maps the local address of a listener, e.g. "127.0.0.1:8081", or
+	// a port alone, e.g. ":8081", to the options that are used instead for its
+	// requests, so that an internal port can be permissive while the public port
+	// stays strict in one process. The Listeners of those options are ignored.
+	// Default is nil.
+	Listeners map[string]*Options
+	// Listener returns the key of Listeners for the request. Default is the
+	// local address of the listener that accepted the request.
+	Listener func(r *http.Request) string
+}
+
+// Decision is the outcome of evaluating a request against the CORS policy. It
+// is injected into the request context so that other middleware (e.g.
+// flamego/csrf) can consult it without parsing the origin again.
+type Decision struct {
+	// Origin is the value of the "Origin" request header, empty for non-CORS
+	// requests.
+	Origin string
+	// Allowed indicates whether the request is a cross-origin request that is
+	// allowed by the policy.
+	Allowed bool
+	// Preflight indicates whether the request is a CORS preflight request.
+	Preflight bool
+	// Rule is the rule that allowed the request, e.g. the matched allowlist entry
+	// "example.com", the "*" wildcard, a pattern of AllowOriginPatterns,
+	// "AllowOriginFunc", "AllowSameHost", "AlwaysAllowOrigin" or
+	// "AllowClientCertificate". It is empty when the request is not allowed.
+	Rule string
+	// Reason is the code of the reason of the request being denied, e.g.
+	// policy.CodeProhibitedDomain. It is empty when the request is not denied.
+	Reason string
+}
+
+// DeniedError is the reason of a request being denied, as passed to
+// ErrorHandler.
+type DeniedError struct {
+	// Status is the RejectStatus of the options.
+	Status int
+	// Code is the code of the reason, e.g. policy.CodeProhibitedDomain.
+	Code string
+	// Origin is the origin of the request, it is empty with RedactOrigin.
+	Origin string
+	// Message is the message of the denial after Messages and RedactOrigin are
+	// applied, as written to the response by default.
+	Message string
+	// Detail and Hint explain the denial in the terms of the options, like the
+	// reason and hint of the error page. They are meant for logs and may name
+	// the origin regardless of RedactOrigin.
+	Detail string
+	Hint   string
+}
+
+func (err *DeniedError) Error() string {
+	return err.Message
+}
+
+// DecisionOf returns the decision of the request and true if the CORS
+// middleware has evaluated it. Because the decision is injected into the
+// request context, access-log middleware that is registered before the CORS
+// middleware can annotate its entries after calling c.Next():
+//
+//	f.Use(func(c flamego.Context, logger *log.Logger) {
+//		c.Next()
+//		if d, ok := cors.DecisionOf(c); ok {
+//			logger.Info("Served", "path", c.Request().URL.Path, "cors_allowed", d.Allowed, "cors_rule", d.Rule)
+//		}
+//	})
+//	f.Use(cors.CORS())
+func DecisionOf(c flamego.Context) (Decision, bool) {
+	v := c.Value(reflect.TypeOf(Decision{}))
+	if !v.IsValid() {
+		return Decision{}, false
+	}
+	d, ok := v.Interface().(Decision)
+	return d, ok
+}
+
+// Policy returns the policy that the middleware evaluates requests against for
+// the options, after applying default values, e.g. to run the exact policy of
+// the server in browser-based tooling with policy.Policy.Expose. It panics on
+// invalid AllowOriginPatterns like the middleware.
+func (opt Options) Policy() *policy.Policy {
+	return policy.New(policyConfig(prepareOptions([]Options{opt})))
+}
+
+// CSPConnectSrc returns the Content-Security-Policy "connect-src" directive that
+// allows the same origins as the options, e.g. "connect-src 'self'
+// https://example.com", so frontends served by the same application stay
+// consistent with the CORS policy.
+func (opt Options) CSPConnectSrc() string {
+	opt = prepareOptions([]Options{opt})
+
+	sources := []string{"'self'"}
+	seen := make(map[string]bool)
+	for _, o := range allowedOrigins(opt) {
+		domain := o.domain
+		if host, port, err := net.SplitHostPort(domain); err == nil && strings.Contains(port, "-") {
+			// CSP has no syntax for port ranges
+			domain = net.JoinHostPort(host, "*")
+		}
+		source := o.scheme + "://" + domain
+		if o.domain == "*" {
+			source = "*"
+		}
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+		sources = append(sources, source)
+	}
+	return "connect-src " + strings.Join(sources, " ")
 }
 
 func prepareOptions(options []Options) Options {
@@ -48,83 +490,818 @@ func prepareOptions(options []Options) Options {
 		opt = options[0]
 	}
 
+	// Copy everything that is owned by the caller, so that mutating the options
+	// afterwards does not race with handling requests.
+	opt.Schemes = cloneStrings(opt.Schemes)
+	opt.AllowDomain = cloneStrings(opt.AllowDomain)
+	opt.AllowOriginPatterns = cloneStrings(opt.AllowOriginPatterns)
+	opt.Methods = cloneStrings(opt.Methods)
+	opt.AllowHeaders = cloneStrings(opt.AllowHeaders)
+	opt.ExposeHeaders = cloneStrings(opt.ExposeHeaders)
+	opt.ExposeTrailers = cloneStrings(opt.ExposeTrailers)
+	opt.TimingAllowOrigin = cloneStrings(opt.TimingAllowOrigin)
+	opt.VaryHeaders = cloneStrings(opt.VaryHeaders)
+	opt.AllowFetchDest = cloneStrings(opt.AllowFetchDest)
+	opt.TrustedProxies = cloneStrings(opt.TrustedProxies)
+	opt.ExemptPaths = cloneStrings(opt.ExemptPaths)
+	if opt.DecorateStatus != nil {
+		opt.DecorateStatus = append([]int{}, opt.DecorateStatus...)
+	}
+	if opt.SkipStatus != nil {
+		opt.SkipStatus = append([]int{}, opt.SkipStatus...)
+	}
+	if opt.SchemeDomains != nil {
+		schemeDomains := make(map[string][]string, len(opt.SchemeDomains))
+		for scheme, domains := range opt.SchemeDomains {
+			schemeDomains[scheme] = cloneStrings(domains)
+		}
+		opt.SchemeDomains = schemeDomains
+	}
+	if opt.Canary != nil {
+		canary := make(map[string]int, len(opt.Canary))
+		for domain, percent := range opt.Canary {
+			canary[domain] = percent
+		}
+		opt.Canary = canary
+	}
+	if opt.Expires != nil {
+		expires := make(map[string]time.Time, len(opt.Expires))
+		for domain, t := range opt.Expires {
+			expires[domain] = t
+		}
+		opt.Expires = expires
+	}
+	if opt.Windows != nil {
+		windows := make(map[string][]policy.Window, len(opt.Windows))
+		for domain, w := range opt.Windows {
+			windows[domain] = append([]policy.Window(nil), w...)
+		}
+		opt.Windows = windows
+	}
+	if opt.Messages != nil {
+		messages := make(map[string]string, len(opt.Messages))
+		for code, message := range opt.Messages {
+			messages[code] = message
+		}
+		opt.Messages = messages
+	}
+	if opt.Listeners != nil {
+		listeners := make(map[string]*Options, len(opt.Listeners))
+		for addr, o := range opt.Listeners {
+			listeners[addr] = o
+		}
+		opt.Listeners = listeners
+	}
+	if opt.FlagDomains != nil {
+		flagDomains := make(map[string]string, len(opt.FlagDomains))
+		for domain, flag := range opt.FlagDomains {
+			flagDomains[domain] = flag
+		}
+		opt.FlagDomains = flagDomains
+	}
+
 	if opt.Scheme == "" {
 		opt.Scheme = "http"
 	}
-	if len(opt.AllowDomain) == 0 {
+	if opt.StrictDefaults {
+		opt.SilentReject = true
+	}
+	if len(opt.AllowDomain) == 0 && !opt.StrictDefaults && opt.AllowOriginFunc == nil && opt.TXTAllowlist == nil && len(opt.AllowOriginPatterns) == 0 {
 		opt.AllowDomain = []string{"*"}
 	}
 	if len(opt.Methods) == 0 {
-		opt.Methods = []string{
-			http.MethodGet,
-			http.MethodOptions,
-			http.MethodPost,
+		if opt.StrictDefaults {
+			opt.Methods = []string{
+				http.MethodGet,
+				http.MethodHead,
+				http.MethodPost,
+			}
+		} else {
+			opt.Methods = []string{
+				http.MethodGet,
+				http.MethodOptions,
+				http.MethodPost,
+			}
+		}
+	}
+	for _, m := range opt.AppendMethods {
+		if !contains(opt.Methods, m) {
+			opt.Methods = append(opt.Methods, m)
 		}
 	}
 	if opt.MaxAge.Seconds() <= 0 {
 		opt.MaxAge = time.Duration(600) * time.Second
 	}
+	if opt.PreflightStatus == 0 {
+		opt.PreflightStatus = http.StatusNoContent
+	}
+	if opt.RejectStatus == 0 {
+		opt.RejectStatus = http.StatusBadRequest
+	}
+	if opt.Quota > 0 && opt.QuotaWindow <= 0 {
+		opt.QuotaWindow = time.Minute
+	}
 
 	return opt
 }
 
+// cloneStrings returns a copy of the slice, preserving nil.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+// DefaultOptions returns the options with default values applied, as used by
+// CORS when no options are given.
+func DefaultOptions() Options {
+	return prepareOptions(nil)
+}
+
+// StaticAssetOptions returns the options for static assets such as web fonts
+// and module scripts, which browsers refuse to load cross-origin without the
+// "Access-Control-Allow-Origin" header: only GET and HEAD requests are allowed,
+// without credentials, and preflight responses are cached for a day. Any domain
+// is allowed when no domains are given. Use it before flamego.Static so that
+// the responses of the static handler are decorated:
+//
+//	f.Use(cors.CORS(cors.StaticAssetOptions()))
+//	f.Use(flamego.Static())
+func StaticAssetOptions(domains ...string) Options {
+	return Options{
+		AllowDomain: domains,
+		Methods:     []string{http.MethodGet, http.MethodHead},
+		MaxAge:      24 * time.Hour,
+	}
+}
+
+// allowAnyDomain returns true if the options allow any domain with the "*"
+// wildcard.
+func allowAnyDomain(opt Options) bool {
+	return opt.AllowOriginFunc == nil && opt.TXTAllowlist == nil && len(opt.SchemeDomains) == 0 && len(opt.AllowDomain) > 0 && opt.AllowDomain[0] == "*"
+}
+
+// Session returns a middleware handler like CORS but for cookie-based sessions,
+// e.g. github.com/flamego/session, where cross-origin requests are sent with
+// credentials. It enables AllowCredentials and panics if the options would
+// reply with the "*" wildcard, which browsers refuse for credentialed requests.
+func Session(options ...Options) flamego.Handler {
+	opt := prepareOptions(options)
+	if allowAnyDomain(opt) {
+		panic(`cors: credentialed requests cannot be allowed by the "*" wildcard, use "!*" or explicit domains`)
+	}
+	opt.AllowCredentials = true
+	return CORS(opt)
+}
+
 // CORS returns a middleware handler that responds to preflight requests with
-// adequate "Access-Control-*" response headers.
+// adequate "Access-Control-*" response headers. The headers are also applied to
+// every response written by subsequent handlers, including redirects.
+// Preflight requests are answered at the requested path without invoking
+// subsequent handlers, thus they are never redirected by routes, unless
+// OptionsPassthrough is set.
+//
+// The options are copied and the returned handler is immutable, mutating the
+// options afterwards has no effect.
 func CORS(options ...Options) flamego.Handler {
+	h := newHandler(options...)
+	return flamego.LoggerInvoker(func(ctx flamego.Context, logger *log.Logger) {
+		h.serve(ctx, logger, ctx.Next)
+	})
+}
+
+// newHandler returns a new handler with values precomputed from the options.
+func newHandler(options ...Options) *handler {
 	opt := prepareOptions(options)
-	return flamego.ContextInvoker(func(ctx flamego.Context) {
-		headers := map[string]string{
-			"Access-Control-Allow-Methods": strings.Join(opt.Methods, ","),
-			"Access-Control-Allow-Headers": ctx.Request().Header.Get("Access-Control-Request-Headers"),
-			"Access-Control-Max-Age":       strconv.FormatFloat(opt.MaxAge.Seconds(), 'f', 0, 64),
-		}
-		if opt.AllowDomain[0] == "*" {
-			headers["Access-Control-Allow-Origin"] = "*"
-		} else {
-			origin := ctx.Request().Header.Get("Origin")
-			if origin == "" {
-				// Skip non-CORS requests
-				return
-			}
+	if opt.Flags == nil && (opt.Flag != "" || len(opt.FlagDomains) > 0) {
+		panic("cors: Flags must be set to use Flag or FlagDomains")
+	}
 
-			u, err := url.Parse(origin)
-			if err != nil {
-				http.Error(ctx.ResponseWriter(), fmt.Sprintf("Unable to parse CORS origin header: %v", err), http.StatusBadRequest)
-				return
-			}
+	if opt.OriginHeader != "" && len(opt.TrustedProxies) == 0 {
+		panic("cors: TrustedProxies must be set to use OriginHeader")
+	}
+	for _, pattern := range opt.ExemptPaths {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			panic("cors: invalid ExemptPaths pattern " + pattern + ": " + err.Error())
+		}
+	}
+	if opt.PreflightStatus < 200 || opt.PreflightStatus > 299 {
+		panic("cors: PreflightStatus must be a 2xx status code")
+	}
+	if opt.RejectStatus < 400 || opt.RejectStatus > 499 {
+		panic("cors: RejectStatus must be a 4xx status code")
+	}
 
-			var ok bool
-			for _, d := range opt.AllowDomain {
-				if u.Host == d ||
-					(opt.AllowSubdomain && strings.HasSuffix(u.Host, "."+d)) ||
-					d == "!*" {
-					ok = true
-					break
-				}
+	h := &handler{
+		opt:     opt,
+		policy:  policy.New(policyConfig(opt)),
+		methods: strings.Join(opt.Methods, ","),
+		expose:  strings.Join(exposeHeaders(opt), ","),
+		headers: strings.Join(allowHeaders(opt), ","),
+		maxAge:  strconv.FormatFloat(opt.MaxAge.Seconds(), 'f', 0, 64),
+	}
+	h.varyHeaders = policy.HeaderNames(opt.VaryHeaders...)
+	if len(opt.AllowFetchDest) > 0 {
+		h.varyHeaders = policy.HeaderNames(append(h.varyHeaders, "Sec-Fetch-Dest")...)
+	}
+	vary := []string{HeaderOrigin}
+	if opt.OriginHeader != "" {
+		vary = append(vary, opt.OriginHeader)
+	}
+	h.vary = policy.HeaderNames(append(vary, h.varyHeaders...)...)
+	// The wildcard is sent to any origin, unless the origin is checked beyond
+	// the allowlist.
+	h.varyOrigin = !allowAnyDomain(opt) || opt.RequireSecureOrigin || opt.CheckReferer ||
+		opt.NormalizeOrigin != nil || opt.AllowClientIP != nil || opt.Quota > 0
+	for _, o := range opt.TimingAllowOrigin {
+		if o == "*" {
+			h.timingWildcard = true
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			panic("cors: invalid TimingAllowOrigin origin " + o)
+		}
+		h.timingOrigins = append(h.timingOrigins, strings.ToLower(o))
+	}
+	h.trustedProxies = parseTrustedProxies(opt.TrustedProxies)
+	if opt.ContentSecurityPolicy {
+		h.csp = opt.CSPConnectSrc()
+	}
+	if opt.Shadow != nil {
+		h.shadow = policy.New(shadowConfig(prepareOptions([]Options{opt.Shadow.Options})))
+	}
+	if len(opt.Listeners) > 0 {
+		h.listeners = make(map[string]*handler, len(opt.Listeners))
+		for addr, o := range opt.Listeners {
+			var lo Options
+			if o != nil {
+				lo = *o
 			}
-			if !ok {
-				http.Error(ctx.ResponseWriter(), fmt.Sprintf("CORS request from prohibited domain %v", origin), http.StatusBadRequest)
-				return
+			lo.Listeners = nil
+			h.listeners[addr] = newHandler(lo)
+		}
+	}
+	if opt.Quota > 0 {
+		h.quota = opt.QuotaStore
+		if h.quota == nil {
+			store := NewMemoryQuotaStore()
+			store.Clock = opt.Clock
+			h.quota = store
+		}
+	}
+	return h
+}
+
+// timingAllowOrigin returns the value of the "Timing-Allow-Origin" header for
+// the origin, or an empty string if the origin may not read the timing data.
+func (h *handler) timingAllowOrigin(origin string) string {
+	if h.timingWildcard {
+		return "*"
+	}
+	if origin != "" && contains(h.timingOrigins, strings.ToLower(origin)) {
+		return origin
+	}
+	return ""
+}
+
+// exposeHeaders returns the list of exposed response headers and trailers of
+// the options.
+func exposeHeaders(opt Options) []string {
+	return policy.HeaderNames(append(cloneStrings(opt.ExposeHeaders), opt.ExposeTrailers...)...)
+}
+
+// requestOrigin returns the origin of the server as addressed by the request.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// allowHeaders returns the list of allowed request headers of the options, or
+// nil if the requested headers are reflected.
+func allowHeaders(opt Options) []string {
+	if opt.AllowHeaders == nil && !opt.ReplaceAllowHeaders && !opt.CDNSafe {
+		return nil
+	}
+	var names []string
+	if !opt.ReplaceAllowHeaders {
+		names = cloneStrings(SafelistedHeaders)
+	}
+	names = append(names, opt.AllowHeaders...)
+	if opt.AllowLegacyXRequestedWith {
+		names = append(names, "X-Requested-With")
+	}
+	// Empty rather than nil so that nothing is allowed
+	return append([]string{}, policy.HeaderNames(names...)...)
+}
+
+// policyConfig returns the policy configuration of the options.
+func policyConfig(opt Options) policy.Config {
+	return policy.Config{
+		Scheme:                 opt.Scheme,
+		Schemes:                opt.Schemes,
+		AllowDomain:            opt.AllowDomain,
+		AllowSubdomain:         opt.AllowSubdomain,
+		SchemeDomains:          opt.SchemeDomains,
+		RequireSecureOrigin:    opt.RequireSecureOrigin,
+		AllowInsecureLocalhost: opt.AllowInsecureLocalhost,
+		CheckReferer:           opt.CheckReferer,
+		Canary:                 opt.Canary,
+		Gated:                  flagGates(opt.Flags, opt.FlagDomains),
+		Expires:                opt.Expires,
+		Windows:                opt.Windows,
+		AllowFetchDest:         opt.AllowFetchDest,
+		AllowOriginPatterns:    compileOriginPatterns(opt.AllowOriginPatterns),
+		AllowOriginFunc:        allowOriginFunc(opt),
+		NormalizeOrigin:        opt.NormalizeOrigin,
+		AllowSameHost:          opt.AllowSameHost,
+		AlwaysAllowOrigin:      opt.AlwaysAllowOrigin,
+		AllowClientCertificate: opt.AllowClientCertificate,
+		AllowClientIP:          opt.AllowClientIP,
+		AllowHeaders:           allowHeaders(opt),
+		Clock:                  opt.Clock,
+		Rand:                   opt.Rand,
+	}
+}
+
+// allowOriginFunc returns the function that reports whether an origin is
+// allowed by AllowOriginFunc or TXTAllowlist, or nil if neither is set.
+func allowOriginFunc(opt Options) func(ctx context.Context, origin string) bool {
+	if opt.TXTAllowlist == nil {
+		return opt.AllowOriginFunc
+	}
+	txt, fn := opt.TXTAllowlist, opt.AllowOriginFunc
+	return func(ctx context.Context, origin string) bool {
+		if fn != nil && fn(ctx, origin) {
+			return true
+		}
+		now := time.Now()
+		switch {
+		case txt.Clock != nil:
+			now = txt.Clock()
+		case opt.Clock != nil:
+			now = opt.Clock()
+		}
+		return txt.allowed(ctx, origin, now)
+	}
+}
+
+// compileOriginPatterns compiles the AllowOriginPatterns, it panics on invalid
+// patterns.
+func compileOriginPatterns(patterns []string) []*regexp.Regexp {
+	if len(patterns) == 0 {
+		return nil
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			panic("cors: invalid origin pattern " + pattern + ": " + err.Error())
+		}
+		res = append(res, re)
+	}
+	return res
+}
+
+// handler is the CORS middleware with values precomputed from the options.
+type handler struct {
+	opt     Options
+	policy  *policy.Policy
+	shadow  *policy.Policy
+	methods string
+	headers string
+	expose  string
+	maxAge  string
+	csp     string
+	quota   QuotaStore
+	// vary is the list of entries of the "Vary" header of responses that
+	// depend on the origin, and varyHeaders is the list of other responses.
+	vary        []string
+	varyHeaders []string
+	// varyOrigin is true when responses depend on the origin, including denials
+	// and non-CORS responses that a cache could serve to allowed origins.
+	varyOrigin bool
+	// timingWildcard is true when any origin may read the timing data, and
+	// timingOrigins is the lowercased list of the other allowed origins.
+	timingWildcard bool
+	timingOrigins  []string
+	// trustedProxies is the parsed TrustedProxies.
+	trustedProxies []*net.IPNet
+
+	listeners map[string]*handler
+}
+
+// compareShadow evaluates the request against the shadow policy and reports
+// the mismatch with the result of the active policy, if any.
+func (h *handler) compareShadow(ctx flamego.Context, logger *log.Logger, req policy.Request, result policy.Result) {
+	var hooked bool
+	shadowResult := h.shadow.EvaluateContext(context.WithValue(ctx.Request().Context(), shadowHookKey{}, &hooked), req)
+	if hooked {
+		return
+	}
+	allowed := result.Denial == nil && result.AllowOrigin != ""
+	shadowAllowed := shadowResult.Denial == nil && shadowResult.AllowOrigin != ""
+	if allowed == shadowAllowed {
+		return
+	}
+
+	h.opt.Shadow.report(ctx.Request().Context(), logger, ShadowMismatch{
+		Origin:        req.Origin,
+		Method:        ctx.Request().Method,
+		Path:          ctx.Request().URL.Path,
+		Allowed:       allowed,
+		ShadowAllowed: shadowAllowed,
+	})
+}
+
+// now returns the current time of the clock.
+func (h *handler) now() time.Time {
+	if h.opt.Clock != nil {
+		return h.opt.Clock()
+	}
+	return time.Now()
+}
+
+// audit records the decision to the audit log, if any.
+func (h *handler) audit(ctx flamego.Context, logger *log.Logger, decision Decision, reason string) {
+	if h.opt.AuditLog == nil {
+		return
+	}
+
+	err := h.opt.AuditLog.record(AuditEntry{
+		Time:     h.now(),
+		Origin:   decision.Origin,
+		Method:   ctx.Request().Method,
+		Path:     ctx.Request().URL.Path,
+		ClientIP: h.clientIP(ctx.Request().Request),
+		Allowed:  decision.Allowed,
+		Reason:   reason,
+	})
+	if err != nil {
+		logger.WithPrefix("cors").Error("Failed to write audit log", "error", err)
+	}
+}
+
+// quotaKey returns the key that the request is counted by for Quota, which is
+// the rule that allowed the origin, or the client IP for rules that allow
+// origins chosen by the client.
+func (h *handler) quotaKey(r *http.Request, rule string) string {
+	switch rule {
+	case "*", "!*", "AllowOriginFunc", "AllowSameHost", "AlwaysAllowOrigin", "AllowClientCertificate":
+		return "ip:" + h.clientIP(r)
+	}
+	return "rule:" + rule
+}
+
+// allowQuota counts the request against the quota of its key, and rejects it
+// with "429 Too Many Requests" when the quota is exceeded. Requests are
+// allowed when the store fails, so that an outage of the store does not take
+// down the API.
+func (h *handler) allowQuota(ctx flamego.Context, logger *log.Logger, origin, rule string) bool {
+	key := h.quotaKey(ctx.Request().Request, rule)
+	n, reset, err := h.quota.Increment(ctx.Request().Context(), key, h.opt.QuotaWindow)
+	if err != nil {
+		logger.WithPrefix("cors").Error("Failed to count request quota", "origin", origin, "key", key, "error", err)
+		return true
+	}
+	if n <= h.opt.Quota {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(reset.Sub(h.now()).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	ctx.ResponseWriter().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(ctx.ResponseWriter(), fmt.Sprintf("CORS request quota exceeded for origin %v", policy.Sanitize(origin)), http.StatusTooManyRequests)
+	return false
+}
+
+// deny rejects the request, or lets it through to the next handler without CORS
+// headers with SilentReject.
+func (h *handler) deny(ctx flamego.Context, logger *log.Logger, next func(), decision Decision, d *policy.Denial) {
+	if h.opt.Recorder != nil {
+		h.opt.Recorder.record(ctx.Request().Request, decision.Origin, corsHeaders(ctx.ResponseWriter().Header()))
+	}
+	if h.opt.DenialLog != nil {
+		h.opt.DenialLog.record(Denial{
+			Time:   h.now(),
+			Origin: decision.Origin,
+			Method: ctx.Request().Method,
+			Path:   ctx.Request().URL.Path,
+			Reason: d.Detail,
+		})
+	}
+	h.audit(ctx, logger, decision, d.Detail)
+	decision.Reason = d.Code
+	ctx.Map(decision)
+	message := d.Message
+	value := policy.Sanitize(d.Value)
+	if h.opt.RedactOrigin && value != "" {
+		message = strings.ReplaceAll(message, value, redacted)
+		value = redacted
+	}
+	if m, ok := h.opt.Messages[d.Code]; ok {
+		message = strings.ReplaceAll(m, "{value}", value)
+	}
+	if flamego.Env() == flamego.EnvTypeDev || h.opt.Diagnose {
+		logger.WithPrefix("cors").Warn("Denied CORS request",
+			"method", ctx.Request().Method,
+			"path", ctx.Request().RequestURI,
+			"origin", decision.Origin,
+			"message", message,
+			"reason", d.Detail,
+		)
+	}
+
+	if !h.opt.SilentReject {
+		// Reasons and hints name the origin as well
+		detailed := h.opt.DebugErrorPage && !h.opt.RedactOrigin
+		origin := policy.Sanitize(decision.Origin)
+		if h.opt.RedactOrigin {
+			origin = ""
+		}
+		if h.opt.ErrorHandler != nil {
+			h.opt.ErrorHandler(ctx, &DeniedError{
+				Status:  h.opt.RejectStatus,
+				Code:    d.Code,
+				Origin:  origin,
+				Message: message,
+				Detail:  d.Detail,
+				Hint:    d.Hint,
+			})
+			return
+		}
+		if detailed && strings.Contains(ctx.Request().Header.Get("Accept"), "text/html") {
+			writeErrorPage(ctx.ResponseWriter(), h.opt.RejectStatus, errorPage{
+				Message: message,
+				Origin:  origin,
+				Reason:  d.Detail,
+				Hint:    d.Hint,
+			})
+			return
+		}
+		if h.opt.ProblemDetails {
+			p := problem{
+				Type:     ProblemTypePrefix + d.Code,
+				Title:    "CORS request denied",
+				Status:   h.opt.RejectStatus,
+				Detail:   message,
+				Instance: ctx.Request().URL.Path,
+				Origin:   origin,
 			}
-			if opt.Scheme != "*" {
-				u.Scheme = opt.Scheme
+			if detailed {
+				p.Reason = d.Detail
+				p.Hint = d.Hint
 			}
-			headers["Access-Control-Allow-Origin"] = u.String()
-			headers["Vary"] = "Origin"
+			writeProblem(ctx.ResponseWriter(), p)
+			return
+		}
+		http.Error(ctx.ResponseWriter(), message, h.opt.RejectStatus)
+		return
+	}
+
+	if ctx.Request().Method == http.MethodOptions && !h.opt.OptionsPassthrough {
+		ctx.ResponseWriter().WriteHeader(h.opt.PreflightStatus)
+	} else {
+		next()
+	}
+}
+
+// serve evaluates the request and decorates the response with CORS headers. It
+// calls the next function to invoke subsequent handlers unless the request has
+// been answered.
+func (h *handler) serve(ctx flamego.Context, logger *log.Logger, next func()) {
+	if len(h.listeners) > 0 {
+		if lh := h.matchListener(ctx.Request().Request); lh != nil {
+			lh.serve(ctx, logger, next)
+			return
+		}
+	}
 
-			if opt.AllowCredentials {
-				headers["Access-Control-Allow-Credentials"] = "true"
+	opt := h.opt
+	for _, pattern := range opt.ExemptPaths {
+		if ok, _ := path.Match(pattern, ctx.Request().URL.Path); ok {
+			next()
+			return
+		}
+	}
+	if opt.Diagnose {
+		// Registered first so that it runs last and sees the final headers
+		registerDiagnosis(ctx, logger, h.origin(ctx.Request().Request))
+	}
+	if opt.Flag != "" && !opt.Flags.Enabled(ctx.Request().Context(), opt.Flag) {
+		next()
+		return
+	}
+
+	if opt.OriginAgentCluster {
+		ctx.ResponseWriter().Header().Set("Origin-Agent-Cluster", "?1")
+	}
+	if opt.PermittedCrossDomainPolicies != "" {
+		ctx.ResponseWriter().Header().Set("X-Permitted-Cross-Domain-Policies", opt.PermittedCrossDomainPolicies)
+	}
+	if h.csp != "" {
+		ctx.ResponseWriter().Header().Set("Content-Security-Policy", h.csp)
+	}
+
+	origin := h.origin(ctx.Request().Request)
+	vary := &addedVary{}
+	ctx.Map(vary)
+	if opt.CDNSafe || h.varyOrigin {
+		vary.add(ctx.ResponseWriter().Header(), h.vary...)
+	} else {
+		vary.add(ctx.ResponseWriter().Header(), h.varyHeaders...)
+	}
+	if ctx.Request().Method == http.MethodOptions {
+		// Preflight responses depend on the requested method and headers
+		vary.add(ctx.ResponseWriter().Header(), HeaderAccessControlRequestMethod, HeaderAccessControlRequestHeaders)
+	}
+	r := ctx.Request().Request
+	req := policy.Request{
+		Origin:        origin,
+		Referer:       r.Header.Get("Referer"),
+		FetchDest:     r.Header.Get("Sec-Fetch-Dest"),
+		Method:        r.Method,
+		RequestMethod: r.Header.Get(HeaderAccessControlRequestMethod),
+		Host:          r.Host,
+		ServerOrigin:  requestOrigin(r),
+		TLS:           r.TLS,
+	}
+	if opt.AllowClientIP != nil || (opt.Shadow != nil && opt.Shadow.Options.AllowClientIP != nil) {
+		req.ClientIP = h.clientIP(r)
+	}
+	decision := Decision{
+		Origin:    origin,
+		Preflight: req.Preflight(),
+	}
+	if decision.Preflight {
+		req.Headers = policy.HeaderNames(r.Header.Values(HeaderAccessControlRequestHeaders)...)
+	}
+	var result policy.Result
+	if opt.ProfilerLabels {
+		labels := pprof.Labels("middleware", "cors", "preflight", strconv.FormatBool(decision.Preflight))
+		pprof.Do(r.Context(), labels, func(labeled context.Context) {
+			result = h.policy.EvaluateContext(labeled, req)
+		})
+	} else {
+		result = h.policy.EvaluateContext(r.Context(), req)
+	}
+	if h.shadow != nil && origin != "" {
+		h.compareShadow(ctx, logger, req, result)
+	}
+	if decision.Preflight && ctx.Request().Context().Err() != nil {
+		// The client has gone away while the hooks were running
+		return
+	}
+	if result.Denial != nil {
+		h.deny(ctx, logger, next, decision, result.Denial)
+		return
+	}
+
+	allowOrigin := result.AllowOrigin
+	decision.Allowed = origin != "" && allowOrigin != ""
+	if decision.Allowed {
+		decision.Rule = result.Rule
+	}
+	ctx.Map(decision)
+	if decision.Allowed && h.opt.AuditLog != nil && h.opt.AuditLog.opt.Allowed {
+		h.audit(ctx, logger, decision, "")
+	}
+	if allowOrigin == "" {
+		next()
+		return
+	}
+
+	// Headers are written eagerly so that they are in place however the
+	// response is written, including streaming responses that flush early.
+	header := ctx.ResponseWriter().Header()
+	header.Set(HeaderAccessControlAllowOrigin, allowOrigin)
+	if allowOrigin != "*" {
+		vary.add(header, h.vary...)
+		if opt.AllowCredentials {
+			header.Set(HeaderAccessControlAllowCredentials, "true")
+		}
+	}
+	if !opt.StrictDefaults || decision.Preflight {
+		header.Set(HeaderAccessControlAllowMethods, h.methods)
+		// Absent values are omitted rather than sent as empty headers
+		if opt.AllowHeaders != nil || opt.ReplaceAllowHeaders || opt.CDNSafe {
+			if h.headers != "" {
+				header.Set(HeaderAccessControlAllowHeaders, h.headers)
 			}
+		} else if names := policy.HeaderNames(ctx.Request().Header.Values(HeaderAccessControlRequestHeaders)...); len(names) > 0 {
+			header.Set(HeaderAccessControlAllowHeaders, strings.Join(names, ","))
+		}
+		header.Set(HeaderAccessControlMaxAge, h.maxAge)
+	}
+	if h.expose != "" && ctx.Request().Method != http.MethodOptions {
+		header.Set(HeaderAccessControlExposeHeaders, h.expose)
+	}
+	if timingAllowOrigin := h.timingAllowOrigin(origin); timingAllowOrigin != "" && ctx.Request().Method != http.MethodOptions {
+		header.Set(HeaderTimingAllowOrigin, timingAllowOrigin)
+		if timingAllowOrigin != "*" {
+			vary.add(header, h.vary...)
+		}
+	}
+	if opt.SunsetNotice > 0 && !result.Expires.IsZero() && !decision.Preflight &&
+		result.Expires.Sub(h.now()) <= opt.SunsetNotice {
+		header.Set("Deprecation", "@"+strconv.FormatInt(result.Expires.Add(-opt.SunsetNotice).Unix(), 10))
+		header.Set("Sunset", result.Expires.UTC().Format(http.TimeFormat))
+		expose := "Deprecation,Sunset"
+		if h.expose != "" {
+			expose = h.expose + "," + expose
+		}
+		header.Set(HeaderAccessControlExposeHeaders, expose)
+		if opt.OnSunset != nil {
+			opt.OnSunset(ctx.Request().Context(), origin, result.Expires)
 		}
+	}
+	if opt.Recorder != nil && origin != "" {
+		opt.Recorder.record(ctx.Request().Request, origin, corsHeaders(header))
+	}
+	// Quotas are enforced after the headers are written, so that the page can
+	// read the rejection and its "Retry-After" header.
+	if h.quota != nil && origin != "" && !h.allowQuota(ctx, logger, origin, result.Rule) {
+		return
+	}
 
+	if opt.PrivateCache && opt.AllowCredentials && allowOrigin != "*" {
+		// The handler may set its own value, which is only known once the
+		// response is written.
+		ctx.ResponseWriter().Before(func(w flamego.ResponseWriter) {
+			if w.Header().Get(HeaderAccessControlAllowCredentials) == "true" {
+				w.Header().Set("Cache-Control", privateCacheControl(w.Header().Get("Cache-Control")))
+			}
+		})
+	}
+	if allowOrigin == "*" && origin != "" {
+		// Browsers never send or store cookies for wildcard responses, which is
+		// usually a sign of a credentialed API that is misconfigured. Cookies are
+		// only known once the response is written, unless the response has opted
+		// out with Skip.
 		ctx.ResponseWriter().Before(func(w flamego.ResponseWriter) {
-			for k, v := range headers {
-				w.Header().Set(k, v)
+			if w.Header().Get(HeaderAccessControlAllowOrigin) == "*" &&
+				len(w.Header().Values("Set-Cookie")) > 0 {
+				logger.WithPrefix("cors").Warn("Response sets cookies under the wildcard origin policy, browsers will ignore them",
+					"method", ctx.Request().Method,
+					"path", ctx.Request().RequestURI,
+					"origin", origin,
+				)
 			}
 		})
+	}
 
-		if ctx.Request().Method == http.MethodOptions {
-			ctx.ResponseWriter().WriteHeader(http.StatusOK)
+	if ctx.Request().Method == http.MethodOptions {
+		if !opt.OptionsPassthrough {
+			ctx.ResponseWriter().WriteHeader(opt.PreflightStatus)
+			return
 		}
-	})
+	} else if len(opt.DecorateStatus) > 0 || len(opt.SkipStatus) > 0 {
+		ctx.MapTo(&statusWriter{ResponseWriter: ctx.ResponseWriter(), opt: opt}, (*http.ResponseWriter)(nil))
+	}
+	next()
+}
+
+// privateCacheControl returns the value of the "Cache-Control" header that
+// prevents shared caches from storing the response, based on the existing
+// value.
+func privateCacheControl(v string) string {
+	directives := make([]string, 0, 4)
+	private := false
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		name := strings.ToLower(strings.SplitN(d, "=", 2)[0])
+		switch name {
+		case "":
+			continue
+		case "no-store":
+			return v
+		case "public", "s-maxage":
+			continue
+		case "private":
+			private = true
+		}
+		directives = append(directives, d)
+	}
+	if !private {
+		directives = append([]string{"private"}, directives...)
+	}
+	return strings.Join(directives, ", ")
+}
+
+// corsHeaders returns the "Vary" and "Access-Control-*" headers of the response
+// header.
+func corsHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for k := range header {
+		if k == "Vary" || strings.HasPrefix(k, "Access-Control-") {
+			headers[k] = header.Get(k)
+		}
+	}
+	return headers
 }
@@ -6,13 +6,25 @@ package cors
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/flamego/cors/policy"
 	"github.com/flamego/flamego"
 )
 
@@ -125,7 +137,7 @@ func TestCustomCORS(t *testing.T) {
 				"Access-Control-Allow-Credentials": "true",
 				"Access-Control-Allow-Headers":     "Content-Type",
 			},
-			wantCode: http.StatusOK,
+			wantCode: http.StatusNoContent,
 		},
 		{
 			name:   "host with port",
@@ -136,7 +148,7 @@ func TestCustomCORS(t *testing.T) {
 			wantHeaders: map[string]string{
 				"Access-Control-Allow-Origin": "https://example.com:8080",
 			},
-			wantCode: http.StatusOK,
+			wantCode: http.StatusNoContent,
 		},
 
 		{
@@ -164,7 +176,7 @@ func TestCustomCORS(t *testing.T) {
 				"Access-Control-Max-Age":           "20",
 				"Access-Control-Allow-Credentials": "true",
 			},
-			wantCode: http.StatusOK,
+			wantCode: http.StatusNoContent,
 		},
 		{
 			name:   "bad host with port",
@@ -197,3 +209,3400 @@ func TestCustomCORS(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultOptions(t *testing.T) {
+	want := Options{
+		Scheme:          "http",
+		AllowDomain:     []string{"*"},
+		Methods:         []string{http.MethodGet, http.MethodOptions, http.MethodPost},
+		MaxAge:          600 * time.Second,
+		PreflightStatus: http.StatusNoContent,
+		RejectStatus:    http.StatusBadRequest,
+	}
+	assert.Equal(t, want, DefaultOptions())
+}
+
+func TestAppendMethods(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Options
+		want []string
+	}{
+		{
+			name: "default methods",
+			opt:  Options{AppendMethods: []string{http.MethodPut, http.MethodDelete}},
+			want: []string{http.MethodGet, http.MethodOptions, http.MethodPost, http.MethodPut, http.MethodDelete},
+		},
+		{
+			name: "strict default methods",
+			opt:  Options{StrictDefaults: true, AppendMethods: []string{http.MethodPatch}},
+			want: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch},
+		},
+		{
+			name: "custom methods",
+			opt: Options{
+				Methods:       []string{http.MethodGet},
+				AppendMethods: []string{http.MethodGet, http.MethodPut},
+			},
+			want: []string{http.MethodGet, http.MethodPut},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, prepareOptions([]Options{test.opt}).Methods)
+		})
+	}
+}
+
+func TestOptionsImmutable(t *testing.T) {
+	opt := Options{
+		AllowDomain: []string{"example.com"},
+		Methods:     []string{http.MethodGet},
+		SchemeDomains: map[string][]string{
+			"https": {"example.com"},
+		},
+	}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(opt))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			opt.AllowDomain[0] = "evil.com"
+			opt.Methods[0] = http.MethodDelete
+			opt.SchemeDomains["https"][0] = "evil.com"
+			opt.SchemeDomains["http"] = []string{"evil.com"}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodOptions, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "https://example.com")
+
+		f.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "https://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, http.MethodGet, resp.Header().Get("Access-Control-Allow-Methods"))
+	}
+	<-done
+}
+
+func TestOnionOrigins(t *testing.T) {
+	const onion = "2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion"
+
+	tests := []struct {
+		name             string
+		options          Options
+		origin           string
+		wantAllowOrigin  string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name: "exact onion host",
+			options: Options{
+				AllowDomain: []string{onion},
+			},
+			origin:          "http://" + onion,
+			wantAllowOrigin: "http://" + onion,
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name: "onion host with port",
+			options: Options{
+				AllowDomain: []string{onion + ":8080"},
+			},
+			origin:          "http://" + onion + ":8080",
+			wantAllowOrigin: "http://" + onion + ":8080",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name: "onion subdomain",
+			options: Options{
+				AllowDomain:    []string{onion},
+				AllowSubdomain: true,
+			},
+			origin:          "http://app." + onion,
+			wantAllowOrigin: "http://app." + onion,
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name: "onion subdomain not allowed",
+			options: Options{
+				AllowDomain: []string{onion},
+			},
+			origin:           "http://app." + onion,
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://app." + onion + "\n",
+		},
+		{
+			name: "different onion host",
+			options: Options{
+				AllowDomain: []string{onion},
+			},
+			origin:           "http://x" + onion,
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://x" + onion + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.options))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestSchemeDomains(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		SchemeDomains: map[string][]string{
+			"https": {"example.com"},
+			"http":  {"localhost:3000"},
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name             string
+		origin           string
+		wantAllowOrigin  string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name:            "https production origin",
+			origin:          "https://example.com",
+			wantAllowOrigin: "https://example.com",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name:            "http localhost origin",
+			origin:          "http://localhost:3000",
+			wantAllowOrigin: "http://localhost:3000",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name:             "http production origin",
+			origin:           "http://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://example.com\n",
+		},
+		{
+			name:             "https localhost origin",
+			origin:           "https://localhost:3000",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain https://localhost:3000\n",
+		},
+		{
+			name:             "unknown scheme",
+			origin:           "ftp://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain ftp://example.com\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestSchemes(t *testing.T) {
+	tests := []struct {
+		name             string
+		opt              Options
+		origin           string
+		wantAllowOrigin  string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name:            "https origin",
+			opt:             Options{Schemes: []string{"https", "capacitor"}, AllowDomain: []string{"example.com", "localhost"}},
+			origin:          "https://example.com",
+			wantAllowOrigin: "https://example.com",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name:            "capacitor origin",
+			opt:             Options{Schemes: []string{"https", "capacitor"}, AllowDomain: []string{"example.com", "localhost"}},
+			origin:          "capacitor://localhost",
+			wantAllowOrigin: "capacitor://localhost",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name:             "other scheme",
+			opt:              Options{Schemes: []string{"https", "capacitor"}, AllowDomain: []string{"example.com", "localhost"}},
+			origin:           "http://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://example.com\n",
+		},
+		{
+			name:            "wildcard allows accepted schemes",
+			opt:             Options{Schemes: []string{"https", "capacitor"}},
+			origin:          "capacitor://localhost",
+			wantAllowOrigin: "*",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name:             "wildcard after other domains",
+			opt:              Options{AllowDomain: []string{"example.com", "*"}, AllowCredentials: true},
+			origin:           "http://evil.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://evil.com\n",
+		},
+		{
+			name:             "wildcard denies other schemes",
+			opt:              Options{Schemes: []string{"https", "capacitor"}},
+			origin:           "http://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain http://example.com\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+
+	assert.Equal(t,
+		[]string{"https://example.com", "capacitor://example.com"},
+		NewManifest(Options{Schemes: []string{"https", "capacitor"}, AllowDomain: []string{"example.com"}}).Origins,
+	)
+}
+
+func TestCanary(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		Canary: map[string]int{
+			"partner.com": 50,
+			"paused.com":  0,
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	allowed := func(origin string, n int) int {
+		var count int
+		for i := 0; i < n; i++ {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", origin)
+
+			f.ServeHTTP(resp, req)
+			if resp.Code == http.StatusOK {
+				assert.Equal(t, origin, resp.Header().Get("Access-Control-Allow-Origin"))
+				count++
+			}
+		}
+		return count
+	}
+
+	assert.Equal(t, 10, allowed("http://example.com", 10))
+	assert.Equal(t, 0, allowed("http://paused.com", 10))
+
+	got := allowed("http://partner.com", 1000)
+	assert.Greater(t, got, 350)
+	assert.Less(t, got, 650)
+}
+
+func TestPortPatterns(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"localhost:*", "127.0.0.1:3000-3999"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://localhost:5173", wantCode: http.StatusOK},
+		{origin: "http://localhost", wantCode: http.StatusOK},
+		{origin: "https://127.0.0.1", wantCode: http.StatusBadRequest},
+		{origin: "http://127.0.0.1:3000", wantCode: http.StatusOK},
+		{origin: "http://127.0.0.1:4000", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			if test.wantCode == http.StatusOK {
+				assert.Equal(t, test.origin, resp.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestLocalhostPortWildcard(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:      []string{"localhost:*", "127.0.0.1:*"},
+		AllowCredentials: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://localhost:5173", wantCode: http.StatusNoContent},
+		{origin: "http://127.0.0.1:3000", wantCode: http.StatusNoContent},
+		{origin: "http://localhost", wantCode: http.StatusNoContent},
+		{origin: "http://127.0.0.1", wantCode: http.StatusNoContent},
+		{origin: "http://localhost.evil.com:5173", wantCode: http.StatusBadRequest},
+		{origin: "http://127.0.0.2:3000", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			if test.wantCode == http.StatusNoContent {
+				assert.Equal(t, test.origin, resp.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
+
+func TestWildcardPatterns(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"*.example.com", "api.*.example.org", "example.net"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://app.example.com", wantCode: http.StatusOK},
+		{origin: "http://a.b.example.com", wantCode: http.StatusOK},
+		{origin: "http://example.com", wantCode: http.StatusBadRequest},
+		{origin: "http://api.eu.example.org", wantCode: http.StatusOK},
+		{origin: "http://www.eu.example.org", wantCode: http.StatusBadRequest},
+		{origin: "http://example.net", wantCode: http.StatusOK},
+		{origin: "http://app.example.net", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			if test.wantCode == http.StatusOK {
+				assert.Equal(t, test.origin, resp.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestRequireSecureOrigin(t *testing.T) {
+	tests := []struct {
+		name             string
+		options          Options
+		origin           string
+		wantAllowOrigin  string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name: "https origin",
+			options: Options{
+				Scheme:              "https",
+				AllowDomain:         []string{"example.com"},
+				RequireSecureOrigin: true,
+			},
+			origin:          "https://example.com",
+			wantAllowOrigin: "https://example.com",
+			wantCode:        http.StatusNoContent,
+		},
+		{
+			name: "http origin",
+			options: Options{
+				Scheme:              "https",
+				AllowDomain:         []string{"example.com"},
+				RequireSecureOrigin: true,
+			},
+			origin:           "http://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from insecure origin http://example.com\n",
+		},
+		{
+			name: "http origin with wildcard",
+			options: Options{
+				RequireSecureOrigin: true,
+			},
+			origin:           "http://example.com",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from insecure origin http://example.com\n",
+		},
+		{
+			name: "http localhost",
+			options: Options{
+				AllowDomain:         []string{"localhost:3000"},
+				RequireSecureOrigin: true,
+			},
+			origin:           "http://localhost:3000",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from insecure origin http://localhost:3000\n",
+		},
+		{
+			name: "http localhost allowed",
+			options: Options{
+				AllowDomain:            []string{"localhost:3000", "127.0.0.1:3000"},
+				RequireSecureOrigin:    true,
+				AllowInsecureLocalhost: true,
+			},
+			origin:          "http://127.0.0.1:3000",
+			wantAllowOrigin: "http://127.0.0.1:3000",
+			wantCode:        http.StatusNoContent,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.options))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestWildcardSetCookieWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		origin    string
+		setCookie bool
+		wantWarn  bool
+	}{
+		{
+			name:      "cross-origin with cookie",
+			origin:    "https://example.com",
+			setCookie: true,
+			wantWarn:  true,
+		},
+		{
+			name:   "cross-origin without cookie",
+			origin: "https://example.com",
+		},
+		{
+			name:      "same-origin with cookie",
+			setCookie: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			f := flamego.NewWithLogger(&buf)
+			f.Use(CORS())
+			f.Get("/", func(c flamego.Context) string {
+				if test.setCookie {
+					c.SetCookie(http.Cookie{Name: "session", Value: "1"})
+				}
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, responseBody, resp.Body.String())
+			assert.Equal(t, test.wantWarn, strings.Contains(buf.String(), "Response sets cookies under the wildcard origin policy"))
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	t.Run("wildcard", func(t *testing.T) {
+		assert.Panics(t, func() { Session() })
+	})
+	t.Run("wildcard with schemes", func(t *testing.T) {
+		assert.Panics(t, func() { Session(Options{Schemes: []string{"https"}}) })
+	})
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Session(Options{
+		Scheme:      "https",
+		AllowDomain: []string{"example.com"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "https://example.com")
+
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, responseBody, resp.Body.String())
+	assert.Equal(t, "https://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestDecision(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      Options
+		method       string
+		reqHeaders   map[string]string
+		wantDecision Decision
+	}{
+		{
+			name:         "same-origin",
+			options:      Options{AllowDomain: []string{"example.com"}},
+			method:       http.MethodGet,
+			wantDecision: Decision{},
+		},
+		{
+			name:    "allowed",
+			options: Options{AllowDomain: []string{"example.com"}},
+			method:  http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "http://example.com",
+			},
+			wantDecision: Decision{
+				Origin:  "http://example.com",
+				Allowed: true,
+				Rule:    "example.com",
+			},
+		},
+		{
+			name:   "wildcard",
+			method: http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "http://example.com",
+			},
+			wantDecision: Decision{
+				Origin:  "http://example.com",
+				Allowed: true,
+				Rule:    "*",
+			},
+		},
+		{
+			name:         "wildcard same-origin",
+			method:       http.MethodGet,
+			wantDecision: Decision{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.options))
+
+			var got Decision
+			f.Get("/", func(d Decision) string {
+				got = d
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			for k, v := range test.reqHeaders {
+				req.Header.Set(k, v)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, responseBody, resp.Body.String())
+			assert.Equal(t, test.wantDecision, got)
+		})
+	}
+}
+
+func TestDecisionOf(t *testing.T) {
+	tests := []struct {
+		name         string
+		origin       string
+		flag         string
+		wantOK       bool
+		wantDecision Decision
+	}{
+		{
+			name:   "allowed",
+			origin: "http://example.com",
+			wantOK: true,
+			wantDecision: Decision{
+				Origin:  "http://example.com",
+				Allowed: true,
+				Rule:    "example.com",
+			},
+		},
+		{
+			name:   "denied",
+			origin: "http://example.org",
+			wantOK: true,
+			wantDecision: Decision{
+				Origin: "http://example.org",
+				Reason: policy.CodeProhibitedDomain,
+			},
+		},
+		{
+			name:   "disabled",
+			origin: "http://example.com",
+			flag:   "cors",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotOK bool
+			var got Decision
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(func(c flamego.Context) {
+				c.Next()
+				got, gotOK = DecisionOf(c)
+			})
+			f.Use(CORS(Options{
+				AllowDomain: []string{"example.com"},
+				Flags:       NewMemoryFlags(),
+				Flag:        test.flag,
+			}))
+			f.Get("/", func() string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantOK, gotOK)
+			assert.Equal(t, test.wantDecision, got)
+		})
+	}
+}
+
+func TestOriginAgentCluster(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(strconv.FormatBool(enabled), func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(Options{
+				AllowDomain:        []string{"example.com"},
+				OriginAgentCluster: enabled,
+			}))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+
+			f.ServeHTTP(resp, req)
+
+			want := ""
+			if enabled {
+				want = "?1"
+			}
+			assert.Equal(t, want, resp.Header().Get("Origin-Agent-Cluster"))
+		})
+	}
+}
+
+func TestPermittedCrossDomainPolicies(t *testing.T) {
+	for _, policy := range []string{"", "none", "master-only"} {
+		t.Run(policy, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(Options{
+				PermittedCrossDomainPolicies: policy,
+			}))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, policy, resp.Header().Get("X-Permitted-Cross-Domain-Policies"))
+			_, ok := resp.Header()["X-Permitted-Cross-Domain-Policies"]
+			assert.Equal(t, policy != "", ok)
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "assets"), 0755))
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:      []string{"example.com"},
+		AllowCredentials: true,
+	}))
+	f.Use(flamego.Static(flamego.StaticOptions{
+		Directory: dir,
+	}))
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect} {
+		code := code
+		f.Get("/"+strconv.Itoa(code), func(c flamego.Context) {
+			c.Redirect("/login", code)
+		})
+	}
+	f.Get("/http", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+
+	tests := []struct {
+		name         string
+		path         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "301",
+			path:         "/301",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/login",
+		},
+		{
+			name:         "302",
+			path:         "/302",
+			wantCode:     http.StatusFound,
+			wantLocation: "/login",
+		},
+		{
+			name:         "307",
+			path:         "/307",
+			wantCode:     http.StatusTemporaryRedirect,
+			wantLocation: "/login",
+		},
+		{
+			name:         "net/http",
+			path:         "/http",
+			wantCode:     http.StatusFound,
+			wantLocation: "/login",
+		},
+		{
+			name:         "static trailing slash",
+			path:         "/assets",
+			wantCode:     http.StatusFound,
+			wantLocation: "/assets/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, test.path, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantLocation, resp.Header().Get("Location"))
+			assert.Equal(t, "http://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestPreflightNotRedirected(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+	}))
+	f.Options("/api", func(c flamego.Context) {
+		c.Redirect("/api/")
+	})
+
+	for _, path := range []string{"/api", "/unknown"} {
+		t.Run(path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, path, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusNoContent, resp.Code)
+			assert.Empty(t, resp.Header().Get("Location"))
+			assert.Equal(t, "http://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestOptionsPassthrough(t *testing.T) {
+	opt := Options{
+		AllowDomain:        []string{"example.com"},
+		OptionsPassthrough: true,
+	}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(opt))
+	f.Options("/dav", func(c flamego.Context) {
+		c.ResponseWriter().Header().Set("Allow", "OPTIONS, PROPFIND")
+		c.ResponseWriter().Header().Set("DAV", "1")
+		c.ResponseWriter().WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name            string
+		origin          string
+		preflight       bool
+		wantCode        int
+		wantAllowOrigin string
+		wantDAV         string
+	}{
+		{
+			name:            "preflight",
+			origin:          "http://example.com",
+			preflight:       true,
+			wantCode:        http.StatusNoContent,
+			wantAllowOrigin: "http://example.com",
+			wantDAV:         "1",
+		},
+		{
+			name:     "denied preflight",
+			origin:   "http://evil.com",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "non-CORS request",
+			wantCode: http.StatusNoContent,
+			wantDAV:  "1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/dav", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				req.Header.Set("Access-Control-Request-Method", "PROPFIND")
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, test.wantDAV, resp.Header().Get("DAV"))
+		})
+	}
+
+	config, err := opt.NginxConfig()
+	assert.Nil(t, err)
+	assert.NotContains(t, config, "return 200;")
+}
+
+func TestPreflightStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      Options
+		wantCode int
+	}{
+		{name: "default", opt: Options{}, wantCode: http.StatusNoContent},
+		{name: "custom", opt: Options{PreflightStatus: http.StatusOK}, wantCode: http.StatusOK},
+		{name: "strict prohibited", opt: Options{StrictDefaults: true, PreflightStatus: http.StatusOK}, wantCode: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Empty(t, resp.Body.String())
+		})
+	}
+
+	assert.PanicsWithValue(t, "cors: PreflightStatus must be a 2xx status code", func() {
+		CORS(Options{PreflightStatus: http.StatusMovedPermanently})
+	})
+}
+
+func TestOptions_Policy(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         Options
+		req             policy.Request
+		wantAllowOrigin string
+		wantCode        string
+	}{
+		{
+			name:            "default",
+			options:         Options{},
+			req:             policy.Request{Origin: "https://example.com"},
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "default scheme",
+			options:         Options{AllowDomain: []string{"example.com"}},
+			req:             policy.Request{Origin: "https://example.com"},
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:     "prohibited preflight header",
+			options:  Options{AllowDomain: []string{"example.com"}, AllowHeaders: []string{"Content-Type"}},
+			req:      policy.Request{Origin: "http://example.com", Method: http.MethodOptions, RequestMethod: http.MethodPut, Headers: []string{"X-Token"}},
+			wantCode: policy.CodeProhibitedHeader,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.options.Policy().Evaluate(test.req)
+			assert.Equal(t, test.wantAllowOrigin, got.AllowOrigin)
+			if test.wantCode == "" {
+				assert.Nil(t, got.Denial)
+				return
+			}
+			if assert.NotNil(t, got.Denial) {
+				assert.Equal(t, test.wantCode, got.Denial.Code)
+			}
+		})
+	}
+}
+
+func TestOptions_CSPConnectSrc(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{
+			name:    "default",
+			options: Options{},
+			want:    "connect-src 'self' *",
+		},
+		{
+			name: "domains",
+			options: Options{
+				Scheme:         "https",
+				AllowDomain:    []string{"example.com", "example.com:8080"},
+				AllowSubdomain: true,
+			},
+			want: "connect-src 'self' https://example.com https://*.example.com https://example.com:8080 https://*.example.com:8080",
+		},
+		{
+			name: "any scheme",
+			options: Options{
+				Scheme:      "*",
+				AllowDomain: []string{"example.com"},
+			},
+			want: "connect-src 'self' http://example.com https://example.com",
+		},
+		{
+			name: "scheme domains",
+			options: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com"},
+					"http":  {"localhost:3000"},
+				},
+			},
+			want: "connect-src 'self' http://localhost:3000 https://example.com",
+		},
+		{
+			name: "ports",
+			options: Options{
+				AllowDomain: []string{"localhost:*", "127.0.0.1:3000-3999"},
+			},
+			want: "connect-src 'self' http://localhost:* http://127.0.0.1:*",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.options.CSPConnectSrc())
+		})
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		Scheme:                "https",
+		AllowDomain:           []string{"example.com"},
+		ContentSecurityPolicy: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+	f.Get("/custom", func(c flamego.Context) string {
+		c.ResponseWriter().Header().Set("Content-Security-Policy", "default-src 'self'")
+		return responseBody
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "connect-src 'self' https://example.com"},
+		{path: "/custom", want: "default-src 'self'"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, test.path, nil)
+			assert.Nil(t, err)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.want, resp.Header().Get("Content-Security-Policy"))
+		})
+	}
+}
+
+func TestCheckReferer(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		Scheme:       "*",
+		AllowDomain:  []string{"example.com"},
+		CheckReferer: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name             string
+		referer          string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name:             "no referer",
+			wantCode:         http.StatusOK,
+			wantResponseBody: responseBody,
+		},
+		{
+			name:             "matching referer",
+			referer:          "https://example.com/app/page?q=1",
+			wantCode:         http.StatusOK,
+			wantResponseBody: responseBody,
+		},
+		{
+			name:             "mismatched host",
+			referer:          "https://evil.com/",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request with mismatched referer https://evil.com/\n",
+		},
+		{
+			name:             "mismatched scheme",
+			referer:          "http://example.com/",
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request with mismatched referer http://example.com/\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "https://example.com")
+			if test.referer != "" {
+				req.Header.Set("Referer", test.referer)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+		})
+	}
+}
+
+func TestStrictDefaults(t *testing.T) {
+	t.Run("no domain allowed", func(t *testing.T) {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{
+			StrictDefaults: true,
+		}))
+		f.Get("/", func(c flamego.Context) string {
+			return responseBody
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "https://example.com")
+
+		f.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, responseBody, resp.Body.String())
+		assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		Scheme:         "https",
+		AllowDomain:    []string{"example.com"},
+		StrictDefaults: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name             string
+		method           string
+		reqHeaders       map[string]string
+		wantHeaders      map[string]string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			reqHeaders: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": http.MethodPost,
+			},
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://example.com",
+				"Access-Control-Allow-Methods":     "GET,HEAD,POST",
+				"Access-Control-Max-Age":           "600",
+				"Access-Control-Allow-Credentials": "",
+			},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:   "actual request",
+			method: http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "https://example.com",
+			},
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://example.com",
+				"Access-Control-Allow-Methods": "",
+				"Access-Control-Max-Age":       "",
+			},
+			wantCode:         http.StatusOK,
+			wantResponseBody: responseBody,
+		},
+		{
+			name:   "prohibited preflight",
+			method: http.MethodOptions,
+			reqHeaders: map[string]string{
+				"Origin":                        "https://evil.com",
+				"Access-Control-Request-Method": http.MethodPost,
+			},
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:   "prohibited actual request",
+			method: http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "https://evil.com",
+			},
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+			wantCode:         http.StatusOK,
+			wantResponseBody: responseBody,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			for k, v := range test.reqHeaders {
+				req.Header.Set(k, v)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			for headerKey, headerValue := range test.wantHeaders {
+				assert.Equal(t, headerValue, resp.Header().Get(headerKey), headerKey)
+			}
+		})
+	}
+}
+
+func TestSilentReject(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:  []string{"example.com"},
+		SilentReject: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		d, ok := DecisionOf(c)
+		assert.True(t, ok)
+		assert.Equal(t, policy.CodeProhibitedDomain, d.Reason)
+		return responseBody
+	})
+
+	tests := []struct {
+		name             string
+		method           string
+		wantCode         int
+		wantResponseBody string
+	}{
+		{name: "actual request", method: http.MethodGet, wantCode: http.StatusOK, wantResponseBody: responseBody},
+		{name: "preflight", method: http.MethodOptions, wantCode: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://evil.com")
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Empty(t, resp.Header().Get(HeaderAccessControlAllowOrigin))
+			assert.Empty(t, resp.Header().Get(HeaderAccessControlAllowMethods))
+		})
+	}
+
+	assert.True(t, prepareOptions([]Options{{StrictDefaults: true}}).SilentReject)
+}
+
+func TestDenialDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		headers map[string]string
+		want    string
+	}{
+		{
+			name: "prohibited domain",
+			options: Options{
+				AllowDomain: []string{"example.com"},
+			},
+			headers: map[string]string{
+				"Origin": "http://b.example.com",
+			},
+			want: "origin host b.example.com did not match allowlist entries [example.com]; AllowSubdomain=false",
+		},
+		{
+			name: "prohibited scheme domain",
+			options: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com"},
+				},
+			},
+			headers: map[string]string{
+				"Origin": "http://example.com",
+			},
+			want: "origin host example.com did not match allowlist entries [] for scheme http; AllowSubdomain=false",
+		},
+		{
+			name: "insecure origin",
+			options: Options{
+				RequireSecureOrigin: true,
+			},
+			headers: map[string]string{
+				"Origin": "http://example.com",
+			},
+			want: "origin scheme http is not https; RequireSecureOrigin=true, AllowInsecureLocalhost=false",
+		},
+		{
+			name: "mismatched referer",
+			options: Options{
+				CheckReferer: true,
+			},
+			headers: map[string]string{
+				"Origin":  "http://example.com",
+				"Referer": "http://evil.com/",
+			},
+			want: "referer http://evil.com/ does not match origin http://example.com; CheckReferer=true",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			f := flamego.NewWithLogger(&buf)
+			f.Use(CORS(test.options))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			assert.Contains(t, buf.String(), "Denied CORS request")
+			assert.Contains(t, buf.String(), test.want)
+		})
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	serve := func(opt Options) *httptest.ResponseRecorder {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(opt))
+		f.Get("/", func(c flamego.Context) string {
+			return responseBody
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "http://b.example.com")
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("debug", func(t *testing.T) {
+		resp := serve(Options{AllowDomain: []string{"example.com"}, DebugErrorPage: true})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+		assert.Contains(t, resp.Body.String(), "<code>http://b.example.com</code>")
+		assert.Contains(t, resp.Body.String(), "origin host b.example.com did not match allowlist entries [example.com]; AllowSubdomain=false")
+		assert.Contains(t, resp.Body.String(), "Add &#34;b.example.com&#34; to AllowDomain")
+	})
+
+	// The allowlist is never sent to clients by default, whatever the env
+	t.Run("default", func(t *testing.T) {
+		resp := serve(Options{AllowDomain: []string{"example.com"}})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+		assert.NotContains(t, resp.Body.String(), "allowlist")
+	})
+}
+
+func BenchmarkCORS(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		options Options
+		method  string
+		headers map[string]string
+	}{
+		{
+			name:   "wildcard",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+		},
+		{
+			name: "allowlist",
+			options: Options{
+				Scheme:           "https",
+				AllowDomain:      []string{"example.com"},
+				AllowCredentials: true,
+			},
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+		},
+		{
+			name: "preflight",
+			options: Options{
+				Scheme:      "https",
+				AllowDomain: []string{"example.com"},
+			},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://example.com",
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "Content-Type",
+			},
+		},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(bm.options))
+			f.Get("/", func(c flamego.Context) {
+				c.ResponseWriter().WriteHeader(http.StatusNoContent)
+			})
+
+			req, err := http.NewRequest(bm.method, "/", nil)
+			assert.Nil(b, err)
+			for k, v := range bm.headers {
+				req.Header.Set(k, v)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestProfilerLabels(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		ProfilerLabels: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name            string
+		origin          string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:            "allowed",
+			origin:          "http://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:     "denied",
+			origin:   "http://evil.com",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestAllowHeadersNormalization(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS())
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodOptions, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-token ,\tx-request-id")
+	req.Header.Add("Access-Control-Request-Headers", ", X-Token,,authorization")
+
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, "content-type,x-token,x-request-id,authorization", resp.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestNoEmptyHeaders(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		method   string
+		wantCode int
+	}{
+		{method: http.MethodGet, wantCode: http.StatusOK},
+		{method: http.MethodOptions, wantCode: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", " , ")
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			for k, v := range resp.Header() {
+				for _, vv := range v {
+					assert.NotEmpty(t, vv, "header %s", k)
+				}
+			}
+			_, ok := resp.Header()["Access-Control-Allow-Headers"]
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestAllowClientIP(t *testing.T) {
+	_, corporate, err := net.ParseCIDR("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		AllowSubdomain: true,
+		TrustedProxies: []string{"192.0.2.1"},
+		AllowClientIP: func(_ context.Context, origin string, ip net.IP) bool {
+			if origin == "https://internal.example.com" {
+				return ip != nil && corporate.Contains(ip)
+			}
+			return true
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name       string
+		origin     string
+		remoteAddr string
+		forwarded  string
+		wantCode   int
+	}{
+		{
+			name:       "public origin",
+			origin:     "https://example.com",
+			remoteAddr: "203.0.113.1:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "internal origin from corporate network",
+			origin:     "https://internal.example.com",
+			remoteAddr: "10.1.2.3:1234",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "internal origin from outside",
+			origin:     "https://internal.example.com",
+			remoteAddr: "203.0.113.1:1234",
+			wantCode:   http.StatusBadRequest,
+		},
+		{
+			name:       "internal origin forwarded from corporate network",
+			origin:     "https://internal.example.com",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "10.1.2.3",
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:       "internal origin forwarded through another proxy",
+			origin:     "https://internal.example.com",
+			remoteAddr: "192.0.2.1:1234",
+			forwarded:  "10.1.2.3, 203.0.113.1",
+			wantCode:   http.StatusBadRequest,
+		},
+		{
+			name:       "internal origin with spoofed forwarded address",
+			origin:     "https://internal.example.com",
+			remoteAddr: "203.0.113.1:1234",
+			forwarded:  "10.1.2.3",
+			wantCode:   http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.RemoteAddr = test.remoteAddr
+			req.Header.Set("Origin", test.origin)
+			if test.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestMessages(t *testing.T) {
+	var buf bytes.Buffer
+	f := flamego.NewWithLogger(&buf)
+	f.Use(CORS(Options{
+		AllowDomain:         []string{"example.com"},
+		RequireSecureOrigin: true,
+		Messages: map[string]string{
+			policy.CodeProhibitedDomain: "Domaine interdit : {value}",
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name             string
+		origin           string
+		wantResponseBody string
+	}{
+		{
+			name:             "translated",
+			origin:           "https://evil.com",
+			wantResponseBody: "Domaine interdit : https://evil.com\n",
+		},
+		{
+			name:             "built-in",
+			origin:           "http://example.com",
+			wantResponseBody: "CORS request from insecure origin http://example.com\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+		})
+	}
+	assert.Contains(t, buf.String(), "Domaine interdit")
+}
+
+func TestExposeHeaders(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		ExposeHeaders:  []string{"X-Total-Count", "ETag", "etag"},
+		ExposeTrailers: []string{"Server-Timing"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		c.ResponseWriter().Header().Set("X-Total-Count", "42")
+		return responseBody
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		wantCode    int
+		wantExposed string
+	}{
+		{name: "actual", method: http.MethodGet, wantCode: http.StatusOK, wantExposed: "X-Total-Count,ETag,Server-Timing"},
+		{name: "preflight", method: http.MethodOptions, wantCode: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantExposed, resp.Header().Get(HeaderAccessControlExposeHeaders))
+		})
+	}
+}
+
+func TestTimingAllowOrigin(t *testing.T) {
+	tests := []struct {
+		name       string
+		opt        Options
+		method     string
+		origin     string
+		wantCode   int
+		wantTiming string
+		wantVary   string
+	}{
+		{
+			name:       "wildcard",
+			opt:        Options{TimingAllowOrigin: []string{"*"}},
+			method:     http.MethodGet,
+			origin:     "http://example.com",
+			wantCode:   http.StatusOK,
+			wantTiming: "*",
+		},
+		{
+			name:       "reflected origin",
+			opt:        Options{TimingAllowOrigin: []string{"http://Example.com"}},
+			method:     http.MethodGet,
+			origin:     "http://example.com",
+			wantCode:   http.StatusOK,
+			wantTiming: "http://example.com",
+			wantVary:   "Origin",
+		},
+		{
+			name:     "unlisted origin",
+			opt:      Options{TimingAllowOrigin: []string{"http://example.com"}},
+			method:   http.MethodGet,
+			origin:   "http://example.org",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "denied origin",
+			opt:      Options{AllowDomain: []string{"example.com"}, TimingAllowOrigin: []string{"*"}},
+			method:   http.MethodGet,
+			origin:   "http://example.org",
+			wantCode: http.StatusBadRequest,
+			wantVary: "Origin",
+		},
+		{
+			name:     "preflight",
+			opt:      Options{TimingAllowOrigin: []string{"*"}},
+			method:   http.MethodOptions,
+			origin:   "http://example.com",
+			wantCode: http.StatusNoContent,
+			wantVary: "Access-Control-Request-Method,Access-Control-Request-Headers",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantTiming, resp.Header().Get(HeaderTimingAllowOrigin))
+			assert.Equal(t, test.wantVary, resp.Header().Get("Vary"))
+		})
+	}
+
+	assert.PanicsWithValue(t, "cors: invalid TimingAllowOrigin origin example.com", func() {
+		CORS(Options{TimingAllowOrigin: []string{"example.com"}})
+	})
+}
+
+func TestExposeTrailers(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		ExposeTrailers: []string{"Grpc-Status", "Grpc-Message"},
+	}))
+	f.Post("/stream", func(c flamego.Context) {
+		w := c.ResponseWriter()
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		for i := 0; i < 3; i++ {
+			_, _ = w.Write([]byte(responseBody))
+			w.Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "done")
+	})
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/stream", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.com")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat(responseBody, 3), string(body))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "http://example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Grpc-Status,Grpc-Message", resp.Header.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	assert.Equal(t, "done", resp.Trailer.Get("Grpc-Message"))
+
+	// Trailers are not exposed by preflight responses
+	req, err = http.NewRequest(http.MethodOptions, server.URL+"/stream", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Access-Control-Expose-Headers"))
+}
+
+func TestAllowClientCertificate(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		AllowClientCertificate: func(_ context.Context, origin string, state *tls.ConnectionState) bool {
+			return origin == "https://dashboard.internal" &&
+				state.VerifiedChains[0][0].Subject.CommonName == "dashboard"
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	verified := func(commonName string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		return &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		origin          string
+		tls             *tls.ConnectionState
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:            "allowed domain",
+			origin:          "https://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:     "no client certificate",
+			origin:   "https://dashboard.internal",
+			tls:      &tls.ConnectionState{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:            "verified client certificate",
+			origin:          "https://dashboard.internal",
+			tls:             verified("dashboard"),
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "https://dashboard.internal",
+		},
+		{
+			name:     "other client certificate",
+			origin:   "https://dashboard.internal",
+			tls:      verified("someone"),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.TLS = test.tls
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestEagerHeaders(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+	}))
+	f.Get("/", func(c flamego.Context) {
+		// Headers are visible to handlers before the response is written
+		assert.Equal(t, "http://example.com", c.ResponseWriter().Header().Get("Access-Control-Allow-Origin"))
+
+		c.ResponseWriter().Flush()
+		_, _ = c.ResponseWriter().Write([]byte(responseBody))
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.com")
+
+	f.ServeHTTP(resp, req)
+	assert.True(t, resp.Flushed)
+	assert.Equal(t, responseBody, resp.Body.String())
+	assert.Equal(t, "http://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+}
+
+func TestAllowHeaders(t *testing.T) {
+	tests := []struct {
+		name             string
+		opt              Options
+		wantCode         int
+		wantAllowHeaders string
+	}{
+		{
+			name:             "reflect requested headers",
+			opt:              Options{},
+			wantCode:         http.StatusNoContent,
+			wantAllowHeaders: "content-type,authorization",
+		},
+		{
+			name:             "extend safelisted headers",
+			opt:              Options{AllowHeaders: []string{"Authorization"}},
+			wantCode:         http.StatusNoContent,
+			wantAllowHeaders: "Accept,Accept-Language,Content-Language,Content-Type,Authorization",
+		},
+		{
+			name:     "replace safelisted headers",
+			opt:      Options{AllowHeaders: []string{"Authorization"}, ReplaceAllowHeaders: true},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:             "replace safelisted headers with requested",
+			opt:              Options{AllowHeaders: []string{"Authorization", "Content-Type"}, ReplaceAllowHeaders: true},
+			wantCode:         http.StatusNoContent,
+			wantAllowHeaders: "Authorization,Content-Type",
+		},
+		{
+			name:     "replace with nothing",
+			opt:      Options{ReplaceAllowHeaders: true},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "prohibited header",
+			opt:      Options{AllowHeaders: []string{"X-Token"}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:             "wildcard",
+			opt:              Options{AllowHeaders: []string{"*"}, ReplaceAllowHeaders: true},
+			wantCode:         http.StatusNoContent,
+			wantAllowHeaders: "*",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "https://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "content-type,authorization")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowHeaders, resp.Header().Get("Access-Control-Allow-Headers"))
+			if test.wantCode == http.StatusBadRequest {
+				assert.Contains(t, resp.Body.String(), "CORS request with prohibited header")
+			}
+		})
+	}
+}
+
+func TestAllowSameHost(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:   []string{"example.com"},
+		AllowSameHost: true,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name     string
+		origin   string
+		host     string
+		wantCode int
+	}{
+		{
+			name:     "same host other port",
+			origin:   "http://localhost:3000",
+			host:     "localhost:8080",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "same IPv6 host other port",
+			origin:   "http://[::1]:3000",
+			host:     "[::1]:8080",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "other host",
+			origin:   "http://evil.com:3000",
+			host:     "localhost:8080",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "http://"+test.host+"/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			if test.wantCode == http.StatusOK {
+				assert.Equal(t, test.origin, resp.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestExpires(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com", "partner.com", "pentest.com"},
+		Expires: map[string]time.Time{
+			"partner.com": time.Now().Add(time.Hour),
+			"pentest.com": time.Now().Add(-time.Hour),
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://example.com", wantCode: http.StatusOK},
+		{origin: "http://partner.com", wantCode: http.StatusOK},
+		{origin: "http://pentest.com", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com", "partner.com", "pentest.com"},
+		Windows: map[string][]policy.Window{
+			"partner.com": {{Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}},
+			"pentest.com": {{Start: time.Now().Add(time.Hour)}},
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://example.com", wantCode: http.StatusOK},
+		{origin: "http://partner.com", wantCode: http.StatusOK},
+		{origin: "http://pentest.com", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+		})
+	}
+}
+
+type errQuotaStore struct{}
+
+func (errQuotaStore) Increment(context.Context, string, time.Duration) (int, time.Time, error) {
+	return 0, time.Time{}, errors.New("unavailable")
+}
+
+func TestQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       Options
+		wantCodes []int
+	}{
+		{
+			name:      "unlimited",
+			opt:       Options{AllowDomain: []string{"example.com"}},
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:      "exceeded",
+			opt:       Options{AllowDomain: []string{"example.com"}, Quota: 2},
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:      "store failure",
+			opt:       Options{AllowDomain: []string{"example.com"}, Quota: 1, QuotaStore: errQuotaStore{}},
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			for _, wantCode := range test.wantCodes {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				assert.Nil(t, err)
+				req.Header.Set("Origin", "http://example.com")
+
+				f.ServeHTTP(resp, req)
+				assert.Equal(t, wantCode, resp.Code)
+				assert.Equal(t, "http://example.com", resp.Header().Get(HeaderAccessControlAllowOrigin))
+				if wantCode == http.StatusTooManyRequests {
+					assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+				}
+			}
+
+			// Requests without an origin are not counted
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+		})
+	}
+
+	t.Run("varying origins", func(t *testing.T) {
+		tests := []struct {
+			name            string
+			opt             Options
+			wantCodes       []int
+			wantOtherClient int
+		}{
+			{
+				name:            "counted by entry",
+				opt:             Options{AllowDomain: []string{"example.com"}, AllowSubdomain: true, Quota: 2},
+				wantCodes:       []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+				wantOtherClient: http.StatusTooManyRequests,
+			},
+			{
+				name:            "wildcard counted by client IP",
+				opt:             Options{Quota: 2},
+				wantCodes:       []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+				wantOtherClient: http.StatusOK,
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				f := flamego.NewWithLogger(&bytes.Buffer{})
+				f.Use(CORS(test.opt))
+				f.Get("/", func(c flamego.Context) string {
+					return responseBody
+				})
+
+				origins := []string{"http://a.example.com", "http://b.example.com", "http://c.example.com"}
+				for i, wantCode := range test.wantCodes {
+					resp := httptest.NewRecorder()
+					req, err := http.NewRequest(http.MethodGet, "/", nil)
+					assert.Nil(t, err)
+					req.RemoteAddr = "192.0.2.1:1234"
+					req.Header.Set("Origin", origins[i])
+
+					f.ServeHTTP(resp, req)
+					assert.Equal(t, wantCode, resp.Code)
+				}
+
+				// Other clients share the quota of an entry, but not of the wildcard
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest(http.MethodGet, "/", nil)
+				assert.Nil(t, err)
+				req.RemoteAddr = "192.0.2.2:1234"
+				req.Header.Set("Origin", "http://example.com")
+				f.ServeHTTP(resp, req)
+				assert.Equal(t, test.wantOtherClient, resp.Code)
+			})
+		}
+	})
+}
+
+func TestSunsetNotice(t *testing.T) {
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	var sunsets []string
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com", "partner.com", "pentest.com"},
+		ExposeTrailers: []string{"Server-Timing"},
+		Expires: map[string]time.Time{
+			"partner.com": expires,
+			"pentest.com": time.Now().Add(30 * 24 * time.Hour),
+		},
+		SunsetNotice: 7 * 24 * time.Hour,
+		OnSunset: func(_ context.Context, origin string, sunset time.Time) {
+			sunsets = append(sunsets, origin)
+			assert.True(t, expires.Equal(sunset))
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin      string
+		wantSunset  string
+		wantExposed string
+	}{
+		{origin: "http://example.com", wantExposed: "Server-Timing"},
+		{origin: "http://pentest.com", wantExposed: "Server-Timing"},
+		{origin: "http://partner.com", wantSunset: expires.UTC().Format(http.TimeFormat), wantExposed: "Server-Timing,Deprecation,Sunset"},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, test.wantSunset, resp.Header().Get("Sunset"))
+			assert.Equal(t, test.wantExposed, resp.Header().Get(HeaderAccessControlExposeHeaders))
+			if test.wantSunset != "" {
+				assert.Equal(t, "@"+strconv.FormatInt(expires.Add(-7*24*time.Hour).Unix(), 10), resp.Header().Get("Deprecation"))
+			}
+		})
+	}
+	assert.Equal(t, []string{"http://partner.com"}, sunsets)
+}
+
+func TestPrivateCache(t *testing.T) {
+	tests := []struct {
+		name         string
+		opt          Options
+		cacheControl string
+		want         string
+	}{
+		{
+			name:         "disabled",
+			opt:          Options{AllowDomain: []string{"example.com"}, AllowCredentials: true},
+			cacheControl: "public, max-age=60",
+			want:         "public, max-age=60",
+		},
+		{
+			name: "without credentials",
+			opt:  Options{AllowDomain: []string{"example.com"}, PrivateCache: true},
+			want: "",
+		},
+		{
+			name: "unset",
+			opt:  Options{AllowDomain: []string{"example.com"}, AllowCredentials: true, PrivateCache: true},
+			want: "private",
+		},
+		{
+			name:         "public",
+			opt:          Options{AllowDomain: []string{"example.com"}, AllowCredentials: true, PrivateCache: true},
+			cacheControl: "public, max-age=60, s-maxage=3600",
+			want:         "private, max-age=60",
+		},
+		{
+			name:         "private",
+			opt:          Options{AllowDomain: []string{"example.com"}, AllowCredentials: true, PrivateCache: true},
+			cacheControl: "max-age=60, private",
+			want:         "max-age=60, private",
+		},
+		{
+			name:         "no-store",
+			opt:          Options{AllowDomain: []string{"example.com"}, AllowCredentials: true, PrivateCache: true},
+			cacheControl: "no-store",
+			want:         "no-store",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				if test.cacheControl != "" {
+					c.ResponseWriter().Header().Set("Cache-Control", test.cacheControl)
+				}
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, test.want, resp.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestCDNSafe(t *testing.T) {
+	tests := []struct {
+		name             string
+		opt              Options
+		origin           string
+		preflight        bool
+		wantCode         int
+		wantAllowHeaders string
+	}{
+		{
+			name:             "non-CORS request",
+			opt:              Options{CDNSafe: true},
+			wantCode:         http.StatusOK,
+			wantAllowHeaders: strings.Join(SafelistedHeaders, ","),
+		},
+		{
+			name:             "wildcard",
+			opt:              Options{CDNSafe: true},
+			origin:           "http://example.com",
+			wantCode:         http.StatusOK,
+			wantAllowHeaders: strings.Join(SafelistedHeaders, ","),
+		},
+		{
+			name:     "denied",
+			opt:      Options{AllowDomain: []string{"example.com"}, CDNSafe: true},
+			origin:   "http://example.org",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:      "preflight without reflection",
+			opt:       Options{AllowDomain: []string{"example.com"}, CDNSafe: true},
+			origin:    "http://example.com",
+			preflight: true,
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:             "preflight with allowed headers",
+			opt:              Options{AllowDomain: []string{"example.com"}, AllowHeaders: []string{"X-Token"}, CDNSafe: true},
+			origin:           "http://example.com",
+			preflight:        true,
+			wantCode:         http.StatusNoContent,
+			wantAllowHeaders: strings.Join(append(cloneStrings(SafelistedHeaders), "X-Token"), ","),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			method := http.MethodGet
+			if test.preflight {
+				method = http.MethodOptions
+			}
+			req, err := http.NewRequest(method, "/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				req.Header.Set(HeaderAccessControlRequestMethod, http.MethodPut)
+				req.Header.Set(HeaderAccessControlRequestHeaders, "X-Token")
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			wantVary := "Origin"
+			if test.preflight {
+				wantVary = "Origin,Access-Control-Request-Method,Access-Control-Request-Headers"
+			}
+			assert.Equal(t, wantVary, resp.Header().Get("Vary"))
+			assert.Equal(t, test.wantAllowHeaders, resp.Header().Get(HeaderAccessControlAllowHeaders))
+		})
+	}
+}
+
+func TestVary(t *testing.T) {
+	tests := []struct {
+		name      string
+		opt       Options
+		origin    string
+		preflight bool
+		vary      string
+		wantCode  int
+		wantVary  string
+	}{
+		{
+			name:     "wildcard",
+			opt:      Options{},
+			origin:   "https://example.com",
+			wantCode: http.StatusOK,
+			wantVary: "",
+		},
+		{
+			name:     "wildcard with checks",
+			opt:      Options{RequireSecureOrigin: true},
+			origin:   "https://example.com",
+			wantCode: http.StatusOK,
+			wantVary: "Origin",
+		},
+		{
+			name:     "allowed",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "https://example.com",
+			wantCode: http.StatusOK,
+			wantVary: "Origin",
+		},
+		{
+			name:     "denied",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "https://example.org",
+			wantCode: http.StatusBadRequest,
+			wantVary: "Origin",
+		},
+		{
+			name:     "non-CORS request",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			wantCode: http.StatusOK,
+			wantVary: "Origin",
+		},
+		{
+			name:      "preflight",
+			opt:       Options{AllowDomain: []string{"example.com"}},
+			origin:    "https://example.com",
+			preflight: true,
+			wantCode:  http.StatusNoContent,
+			wantVary:  "Origin,Access-Control-Request-Method,Access-Control-Request-Headers",
+		},
+		{
+			name:      "denied preflight",
+			opt:       Options{AllowDomain: []string{"example.com"}},
+			origin:    "https://example.org",
+			preflight: true,
+			wantCode:  http.StatusBadRequest,
+			wantVary:  "Origin,Access-Control-Request-Method,Access-Control-Request-Headers",
+		},
+		{
+			name:      "wildcard preflight",
+			opt:       Options{},
+			origin:    "https://example.com",
+			preflight: true,
+			wantCode:  http.StatusNoContent,
+			wantVary:  "Access-Control-Request-Method,Access-Control-Request-Headers",
+		},
+		{
+			name:     "existing entries",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "https://example.com",
+			vary:     "Accept-Encoding, origin",
+			wantCode: http.StatusOK,
+			wantVary: "Accept-Encoding,origin",
+		},
+		{
+			name:     "existing wildcard",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "https://example.com",
+			vary:     "*",
+			wantCode: http.StatusOK,
+			wantVary: "*",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			if test.vary != "" {
+				f.Use(func(c flamego.Context) {
+					c.ResponseWriter().Header().Set("Vary", test.vary)
+				})
+			}
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			method := http.MethodGet
+			if test.preflight {
+				method = http.MethodOptions
+			}
+			req, err := http.NewRequest(method, "/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantVary, resp.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		NormalizeOrigin: func(_ context.Context, raw string) (string, error) {
+			if strings.Contains(raw, "legacy") {
+				return "", errors.New("legacy hostnames are retired")
+			}
+			return strings.Replace(raw, "://www.", "://", 1), nil
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin          string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{origin: "http://example.com", wantCode: http.StatusOK, wantAllowOrigin: "http://example.com"},
+		{origin: "http://www.example.com", wantCode: http.StatusOK, wantAllowOrigin: "http://www.example.com"},
+		{origin: "http://www.example.org", wantCode: http.StatusBadRequest},
+		{origin: "http://legacy.example.com", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+		})
+	}
+}
+
+func TestVaryHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      Options
+		origin   string
+		wantVary string
+	}{
+		{
+			name:     "wildcard",
+			opt:      Options{VaryHeaders: []string{"X-Tenant", "x-tenant", "X-API-Version"}},
+			origin:   "http://example.com",
+			wantVary: "X-Tenant,X-API-Version",
+		},
+		{
+			name:     "reflected",
+			opt:      Options{AllowDomain: []string{"example.com"}, VaryHeaders: []string{"X-Tenant"}},
+			origin:   "http://example.com",
+			wantVary: "Origin,X-Tenant",
+		},
+		{
+			name:     "non-CORS request",
+			opt:      Options{AllowDomain: []string{"example.com"}, VaryHeaders: []string{"X-Tenant"}},
+			wantVary: "Origin,X-Tenant",
+		},
+		{
+			name:     "CDN-safe",
+			opt:      Options{VaryHeaders: []string{"X-Tenant"}, CDNSafe: true},
+			origin:   "http://example.com",
+			wantVary: "Origin,X-Tenant",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, test.wantVary, resp.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug bool
+		want  string
+	}{
+		{
+			name: "default",
+			want: `{"type":"tag:flamego.dev,2021:cors:prohibited_domain","title":"CORS request denied","status":400,"detail":"CORS request from prohibited domain http://example.org","instance":"/api","origin":"http://example.org"}` + "\n",
+		},
+		{
+			name:  "debug",
+			debug: true,
+			want:  `{"type":"tag:flamego.dev,2021:cors:prohibited_domain","title":"CORS request denied","status":400,"detail":"CORS request from prohibited domain http://example.org","instance":"/api","origin":"http://example.org","reason":"origin host example.org did not match allowlist entries [example.com]; AllowSubdomain=false","hint":"Add \"example.org\" to AllowDomain, or set AllowSubdomain if it is a subdomain of an allowed domain."}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(Options{
+				AllowDomain:    []string{"example.com"},
+				ProblemDetails: true,
+				DebugErrorPage: test.debug,
+			}))
+			f.Get("/api", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/api", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.org")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			assert.Equal(t, "application/problem+json", resp.Header().Get("Content-Type"))
+			assert.Equal(t, test.want, resp.Body.String())
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var got error
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		ProblemDetails: true,
+		ErrorHandler: func(c flamego.Context, reason error) {
+			got = reason
+			c.ResponseWriter().Header().Set("Content-Type", "application/json")
+			c.ResponseWriter().WriteHeader(http.StatusForbidden)
+			_, _ = c.ResponseWriter().Write([]byte(`{"error":"` + reason.Error() + `"}`))
+		},
+	}))
+	f.Get("/api", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/api", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.org")
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, `{"error":"CORS request from prohibited domain http://example.org"}`, resp.Body.String())
+
+	var denied *DeniedError
+	assert.True(t, errors.As(got, &denied))
+	assert.Equal(t, http.StatusBadRequest, denied.Status)
+	assert.Equal(t, policy.CodeProhibitedDomain, denied.Code)
+	assert.Equal(t, "http://example.org", denied.Origin)
+	assert.Equal(t, "origin host example.org did not match allowlist entries [example.com]; AllowSubdomain=false", denied.Detail)
+}
+
+func TestRejectStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    Options
+		origin string
+	}{
+		{
+			name:   "prohibited domain",
+			opt:    Options{AllowDomain: []string{"example.com"}, RejectStatus: http.StatusForbidden},
+			origin: "https://example.org",
+		},
+		{
+			name:   "prohibited scheme",
+			opt:    Options{Schemes: []string{"https"}, AllowDomain: []string{"example.com"}, RejectStatus: http.StatusForbidden},
+			origin: "http://example.com",
+		},
+		{
+			name:   "invalid origin",
+			opt:    Options{AllowDomain: []string{"example.com"}, RejectStatus: http.StatusForbidden},
+			origin: "http://[::1",
+		},
+		{
+			name:   "problem details",
+			opt:    Options{AllowDomain: []string{"example.com"}, RejectStatus: http.StatusForbidden, ProblemDetails: true},
+			origin: "https://example.org",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusForbidden, resp.Code)
+			if test.opt.ProblemDetails {
+				assert.Contains(t, resp.Body.String(), `"status":403`)
+			}
+		})
+	}
+
+	assert.PanicsWithValue(t, "cors: RejectStatus must be a 4xx status code", func() {
+		CORS(Options{RejectStatus: http.StatusOK})
+	})
+}
+
+func TestStaticAssetOptions(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "font.woff2"), []byte("wOF2"), 0600)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name            string
+		opt             Options
+		method          string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:            "any domain",
+			opt:             StaticAssetOptions(),
+			method:          http.MethodGet,
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "allowed domain",
+			opt:             StaticAssetOptions("example.com"),
+			method:          http.MethodHead,
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:     "prohibited domain",
+			opt:      StaticAssetOptions("example.org"),
+			method:   http.MethodGet,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Use(flamego.Static(flamego.StaticOptions{Directory: dir}))
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/font.woff2", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+			if test.wantCode == http.StatusOK {
+				assert.Equal(t, "GET,HEAD", resp.Header().Get(HeaderAccessControlAllowMethods))
+				assert.Equal(t, "86400", resp.Header().Get(HeaderAccessControlMaxAge))
+				assert.Empty(t, resp.Header().Get(HeaderAccessControlAllowCredentials))
+			}
+		})
+	}
+}
+
+func TestAllowFetchDest(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		AllowFetchDest: []string{"empty"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name      string
+		origin    string
+		fetchDest string
+		wantCode  int
+	}{
+		{name: "fetch", origin: "http://example.com", fetchDest: "empty", wantCode: http.StatusOK},
+		{name: "legacy browser", origin: "http://example.com", wantCode: http.StatusOK},
+		{name: "iframe", origin: "http://example.com", fetchDest: "iframe", wantCode: http.StatusBadRequest},
+		{name: "script", fetchDest: "script", wantCode: http.StatusBadRequest},
+		{name: "document", fetchDest: "document", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.fetchDest != "" {
+				req.Header.Set("Sec-Fetch-Dest", test.fetchDest)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Contains(t, resp.Header().Get("Vary"), "Sec-Fetch-Dest")
+		})
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	type contextKey struct{}
+
+	t.Run("hooks", func(t *testing.T) {
+		var got []interface{}
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{
+			AllowDomain: []string{"example.com"},
+			NormalizeOrigin: func(ctx context.Context, raw string) (string, error) {
+				got = append(got, ctx.Value(contextKey{}))
+				return raw, nil
+			},
+			AllowClientIP: func(ctx context.Context, _ string, _ net.IP) bool {
+				got = append(got, ctx.Value(contextKey{}))
+				return true
+			},
+		}))
+		f.Get("/", func(c flamego.Context) string {
+			return responseBody
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req = req.WithContext(context.WithValue(req.Context(), contextKey{}, "request"))
+		req.Header.Set("Origin", "http://example.com")
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		f.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, []interface{}{"request", "request"}, got)
+	})
+
+	t.Run("canceled preflight", func(t *testing.T) {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{
+			AllowDomain: []string{"example.com"},
+			AllowClientIP: func(context.Context, string, net.IP) bool {
+				return false
+			},
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(ctx, http.MethodOptions, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "http://example.com")
+		req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+		req.RemoteAddr = "192.0.2.1:1234"
+
+		f.ServeHTTP(resp, req)
+		assert.Empty(t, resp.Body.String())
+		assert.Empty(t, resp.Header().Get(HeaderAccessControlAllowOrigin))
+	})
+}
+
+func TestRedactOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      Options
+		origin   string
+		accept   string
+		wantBody string
+	}{
+		{
+			name:     "escaped",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "http://evil.com\x1b[2K",
+			wantBody: `Unable to parse CORS origin header: parse "http://evil.com\x1b[2K": net/url: invalid control character in URL` + "\n",
+		},
+		{
+			name:     "truncated",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "http://" + strings.Repeat("a", 300) + ".com",
+			wantBody: "CORS request from prohibited domain http://" + strings.Repeat("a", 249) + "...\n",
+		},
+		{
+			name:     "redacted",
+			opt:      Options{AllowDomain: []string{"example.com"}, RedactOrigin: true},
+			origin:   "http://evil.com",
+			wantBody: "CORS request from prohibited domain [redacted]\n",
+		},
+		{
+			name: "redacted message",
+			opt: Options{
+				AllowDomain:  []string{"example.com"},
+				RedactOrigin: true,
+				Messages:     map[string]string{policy.CodeProhibitedDomain: "Origin {value} is not allowed"},
+			},
+			origin:   "http://evil.com",
+			wantBody: "Origin [redacted] is not allowed\n",
+		},
+		{
+			name:     "redacted problem details",
+			opt:      Options{AllowDomain: []string{"example.com"}, RedactOrigin: true, ProblemDetails: true},
+			origin:   "http://evil.com",
+			wantBody: `{"type":"tag:flamego.dev,2021:cors:prohibited_domain","title":"CORS request denied","status":400,"detail":"CORS request from prohibited domain [redacted]","instance":"/"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+			assert.Equal(t, test.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com", "partner.com"},
+		Expires:     map[string]time.Time{"partner.com": now.Add(time.Hour)},
+		Canary:      map[string]int{"canary.com": 50},
+		Rand: func(n int) int {
+			assert.Equal(t, 100, n)
+			return 0
+		},
+		Quota:       2,
+		QuotaWindow: time.Minute,
+		Clock:       func() time.Time { return now },
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	do := func(origin string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", origin)
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, do("http://canary.com").Code)
+	assert.Equal(t, http.StatusOK, do("http://partner.com").Code)
+	assert.Equal(t, http.StatusOK, do("http://partner.com").Code)
+
+	now = now.Add(30 * time.Second)
+	resp := do("http://partner.com")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusBadRequest, do("http://partner.com").Code)
+}
+
+func TestAlwaysAllowOrigin(t *testing.T) {
+	tests := []struct {
+		name            string
+		opt             Options
+		origin          string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:     "disabled",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:            "non-CORS",
+			opt:             Options{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://api.example.org",
+		},
+		{
+			name:            "same-origin",
+			opt:             Options{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			origin:          "http://api.example.org",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://api.example.org",
+		},
+		{
+			name:     "same-origin disabled",
+			opt:      Options{AllowDomain: []string{"example.com"}},
+			origin:   "http://api.example.org",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "other port",
+			opt:      Options{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			origin:   "http://api.example.org:8080",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:            "cross-origin",
+			opt:             Options{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			origin:          "http://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:            "wildcard",
+			opt:             Options{AlwaysAllowOrigin: true},
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "*",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Post("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodPost, "http://api.example.org/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+		})
+	}
+}
+
+func TestAllowLegacyXRequestedWith(t *testing.T) {
+	tests := []struct {
+		name             string
+		opt              Options
+		wantCode         int
+		wantAllowHeaders string
+	}{
+		{
+			name:             "reflected",
+			wantCode:         http.StatusNoContent,
+			opt:              Options{AllowLegacyXRequestedWith: true},
+			wantAllowHeaders: "X-Requested-With",
+		},
+		{
+			name:             "allowed",
+			wantCode:         http.StatusNoContent,
+			opt:              Options{AllowHeaders: []string{"Authorization"}, AllowLegacyXRequestedWith: true},
+			wantAllowHeaders: "Accept,Accept-Language,Content-Language,Content-Type,Authorization,X-Requested-With",
+		},
+		{
+			name:             "replaced",
+			wantCode:         http.StatusNoContent,
+			opt:              Options{AllowHeaders: []string{"Authorization", "x-requested-with"}, ReplaceAllowHeaders: true, AllowLegacyXRequestedWith: true},
+			wantAllowHeaders: "Authorization,x-requested-with",
+		},
+		{
+			name:     "disabled",
+			opt:      Options{AllowHeaders: []string{"Authorization"}},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodOptions, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+			req.Header.Set(HeaderAccessControlRequestHeaders, "X-Requested-With")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowHeaders, resp.Header().Get(HeaderAccessControlAllowHeaders))
+		})
+	}
+}
+
+func TestOriginHeader(t *testing.T) {
+	assert.PanicsWithValue(t, "cors: TrustedProxies must be set to use OriginHeader", func() {
+		CORS(Options{OriginHeader: "X-Original-Origin"})
+	})
+	assert.PanicsWithValue(t, "cors: invalid trusted proxy 10.0.0.0/33: invalid CIDR address: 10.0.0.0/33", func() {
+		CORS(Options{OriginHeader: "X-Original-Origin", TrustedProxies: []string{"10.0.0.0/33"}})
+	})
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		OriginHeader:   "X-Original-Origin",
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name            string
+		remoteAddr      string
+		origin          string
+		originalOrigin  string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:            "trusted proxy",
+			remoteAddr:      "10.1.2.3:1234",
+			origin:          "https://gateway.internal",
+			originalOrigin:  "http://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:            "trusted proxy address",
+			remoteAddr:      "192.0.2.1:1234",
+			originalOrigin:  "http://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:           "untrusted peer",
+			remoteAddr:     "192.0.2.2:1234",
+			origin:         "http://evil.com",
+			originalOrigin: "http://example.com",
+			wantCode:       http.StatusBadRequest,
+		},
+		{
+			name:            "no alternate header",
+			remoteAddr:      "10.1.2.3:1234",
+			origin:          "http://example.com",
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.RemoteAddr = test.remoteAddr
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.originalOrigin != "" {
+				req.Header.Set("X-Original-Origin", test.originalOrigin)
+			}
+			// Forwarded client addresses do not make the peer trusted
+			req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+			if test.wantAllowOrigin != "" {
+				assert.Equal(t, "Origin,X-Original-Origin", resp.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestAllowOriginFunc(t *testing.T) {
+	type contextKey struct{}
+	registered := map[string]bool{"https://customer.com": true}
+
+	opt := Options{
+		AllowOriginFunc: func(ctx context.Context, origin string) bool {
+			assert.Equal(t, "request", ctx.Value(contextKey{}))
+			return registered[origin]
+		},
+		AllowCredentials: true,
+	}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Session(opt))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin          string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{origin: "", wantCode: http.StatusOK},
+		{origin: "https://customer.com", wantCode: http.StatusOK, wantAllowOrigin: "https://customer.com"},
+		{origin: "https://other.com", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req = req.WithContext(context.WithValue(req.Context(), contextKey{}, "request"))
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+		})
+	}
+
+	// Dynamic origins are not published
+	assert.Equal(t, []string{}, NewManifest(opt).Origins)
+}
+
+func TestAllowOriginPatterns(t *testing.T) {
+	opt := Options{
+		AllowDomain:         []string{"example.com"},
+		AllowOriginPatterns: []string{`http://pr-\d+\.preview\.example\.com`},
+	}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(opt))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{origin: "http://example.com", wantCode: http.StatusOK},
+		{origin: "http://pr-42.preview.example.com", wantCode: http.StatusOK},
+		{origin: "http://pr-42.preview.example.com.evil.com", wantCode: http.StatusBadRequest},
+		{origin: "http://staging.preview.example.com", wantCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", test.origin)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			if test.wantCode == http.StatusOK {
+				assert.Equal(t, test.origin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+			}
+		})
+	}
+
+	// Patterns are not published
+	assert.Equal(t, []string{"http://example.com"}, NewManifest(opt).Origins)
+
+	assert.PanicsWithValue(t, "cors: invalid origin pattern (: error parsing regexp: missing closing ): `(`", func() {
+		CORS(Options{AllowOriginPatterns: []string{"("}})
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package corstest provides utilities for end-to-end testing of the CORS
+// middleware.
+package corstest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/flamego/cors"
+	"github.com/flamego/flamego"
+)
+
+// Route is a route to be registered on the test server.
+type Route struct {
+	// Method is the HTTP method of the route, e.g. "GET", or "*" for any method.
+	Method string
+	// Path is the path of the route, e.g. "/api".
+	Path string
+	// Handler is the handler of the route.
+	Handler flamego.Handler
+}
+
+// Server is a test server with the CORS middleware wired.
+type Server struct {
+	*httptest.Server
+}
+
+// NewServer starts and returns a new Server that serves the given routes behind
+// the CORS middleware with the given options. The caller should call Close
+// when finished, to shut it down.
+func NewServer(opts cors.Options, routes ...Route) *Server {
+	f := flamego.NewWithLogger(io.Discard)
+	f.Use(cors.CORS(opts))
+	for _, r := range routes {
+		f.Route(r.Method, r.Path, []flamego.Handler{r.Handler})
+	}
+	return &Server{
+		Server: httptest.NewServer(f),
+	}
+}
+
+// Prober returns a new Prober that sends requests to the server.
+func (s *Server) Prober() *Prober {
+	return &Prober{
+		baseURL: s.URL,
+		client:  s.Client(),
+	}
+}
+
+// Response is a response received by the Prober.
+type Response struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// Header is the header of the response.
+	Header http.Header
+	// Body is the body of the response.
+	Body string
+}
+
+// Result is the outcome of probing a cross-origin request.
+type Result struct {
+	// Preflight is the response to the preflight request, it is nil when the
+	// request does not need a preflight.
+	Preflight *Response
+	// PreflightAllowed indicates whether a browser would accept the preflight
+	// response.
+	PreflightAllowed bool
+	// Response is the response to the actual request, it is nil when the
+	// preflight is not accepted.
+	Response *Response
+	// Allowed indicates whether a browser would expose the response of the
+	// actual request to the requesting page.
+	Allowed bool
+}
+
+// Prober sends cross-origin requests to a server and checks the responses like
+// a browser would, with cors.Transport.
+type Prober struct {
+	baseURL string
+	client  *http.Client
+}
+
+// recorder is an http.RoundTripper that records the responses of the preflight
+// and the actual request before cors.Transport checks them.
+type recorder struct {
+	base      http.RoundTripper
+	preflight *Response
+	response  *Response
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read body")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       string(body),
+	}
+	if req.Method == http.MethodOptions && req.Header.Get(cors.HeaderAccessControlRequestMethod) != "" {
+		r.preflight = recorded
+	} else {
+		r.response = recorded
+	}
+	return resp, nil
+}
+
+// PreflightThenRequest sends a request with the method to the path from the
+// origin, preceded by a preflight request when the method is not a simple
+// method, and returns whether a browser would have allowed it.
+func (p *Prober) PreflightThenRequest(origin, method, path string) (*Result, error) {
+	base := p.client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	rec := &recorder{base: base}
+	client := &http.Client{
+		Transport: &cors.Transport{Origin: origin, Base: rec},
+	}
+
+	req, err := http.NewRequest(method, p.baseURL+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+
+	result := &Result{
+		Preflight:        rec.preflight,
+		PreflightAllowed: true,
+		Response:         rec.response,
+		Allowed:          err == nil,
+	}
+	var blocked *cors.BlockedError
+	if err != nil && !errors.As(err, &blocked) {
+		return nil, errors.Wrap(err, "request")
+	}
+	if blocked != nil && strings.HasPrefix(blocked.Reason, "preflight: ") {
+		result.PreflightAllowed = false
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package corstest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/cors"
+	"github.com/flamego/flamego"
+)
+
+func TestProber_PreflightThenRequest(t *testing.T) {
+	s := NewServer(
+		cors.Options{
+			Scheme:      "https",
+			AllowDomain: []string{"example.com"},
+			Methods:     []string{http.MethodGet, http.MethodPut},
+		},
+		Route{
+			Method: "*",
+			Path:   "/api",
+			Handler: func(c flamego.Context) string {
+				return "ok"
+			},
+		},
+	)
+	defer s.Close()
+
+	tests := []struct {
+		name                 string
+		origin               string
+		method               string
+		wantPreflight        bool
+		wantPreflightAllowed bool
+		wantAllowed          bool
+	}{
+		{
+			name:                 "simple request",
+			origin:               "https://example.com",
+			method:               http.MethodGet,
+			wantPreflightAllowed: true,
+			wantAllowed:          true,
+		},
+		{
+			name:                 "preflighted request",
+			origin:               "https://example.com",
+			method:               http.MethodPut,
+			wantPreflight:        true,
+			wantPreflightAllowed: true,
+			wantAllowed:          true,
+		},
+		{
+			name:          "method not allowed",
+			origin:        "https://example.com",
+			method:        http.MethodDelete,
+			wantPreflight: true,
+		},
+		{
+			name:          "prohibited origin",
+			origin:        "https://evil.com",
+			method:        http.MethodPut,
+			wantPreflight: true,
+		},
+		{
+			name:                 "prohibited simple request",
+			origin:               "https://evil.com",
+			method:               http.MethodGet,
+			wantPreflightAllowed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := s.Prober().PreflightThenRequest(test.origin, test.method, "/api")
+			assert.Nil(t, err)
+			assert.Equal(t, test.wantPreflight, got.Preflight != nil)
+			assert.Equal(t, test.wantPreflightAllowed, got.PreflightAllowed)
+			assert.Equal(t, test.wantAllowed, got.Allowed)
+			if test.wantAllowed {
+				assert.Equal(t, "ok", got.Response.Body)
+			}
+		})
+	}
+}
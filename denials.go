@@ -0,0 +1,114 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flamego/flamego"
+)
+
+// Denial is a record of a request that was denied by the CORS policy.
+type Denial struct {
+	// Time is when the request was denied.
+	Time time.Time `json:"time"`
+	// Origin is the value of the "Origin" request header.
+	Origin string `json:"origin"`
+	// Method is the method of the request.
+	Method string `json:"method"`
+	// Path is the URL path of the request.
+	Path string `json:"path"`
+	// Reason names the check that failed.
+	Reason string `json:"reason"`
+}
+
+// DenialLog is an in-memory ring buffer of the most recent denials, it is safe
+// for concurrent use.
+type DenialLog struct {
+	mu      sync.Mutex
+	entries []Denial
+	next    int
+	full    bool
+}
+
+// NewDenialLog returns a new DenialLog that keeps up to the given number of the
+// most recent denials.
+func NewDenialLog(size int) *DenialLog {
+	if size <= 0 {
+		size = 100
+	}
+	return &DenialLog{
+		entries: make([]Denial, size),
+	}
+}
+
+func (l *DenialLog) record(d Denial) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = d
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Denials returns the recorded denials with the most recent first.
+func (l *DenialLog) Denials() []Denial {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	denials := make([]Denial, 0, n)
+	for i := 1; i <= n; i++ {
+		denials = append(denials, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return denials
+}
+
+var denialsTemplate = template.Must(template.New("denials").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Recent CORS denials</title>
+</head>
+<body>
+<h1>Recent CORS denials</h1>
+<table>
+<tr><th>Time</th><th>Origin</th><th>Method</th><th>Path</th><th>Reason</th></tr>
+{{range .}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Origin}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Handler returns a handler that shows the recorded denials, as an HTML page
+// when the request accepts "text/html" and as JSON otherwise. It is meant for
+// development and should not be mounted publicly in production.
+func (l *DenialLog) Handler() flamego.Handler {
+	return func(c flamego.Context) {
+		denials := l.Denials()
+		w := c.ResponseWriter()
+		if strings.Contains(c.Request().Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_ = denialsTemplate.Execute(w, denials)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(denials)
+	}
+}
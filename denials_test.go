@@ -0,0 +1,86 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestDenialLog(t *testing.T) {
+	l := NewDenialLog(2)
+	assert.Empty(t, l.Denials())
+
+	for i := 1; i <= 3; i++ {
+		l.record(Denial{Origin: "http://" + strconv.Itoa(i) + ".example.com"})
+	}
+
+	got := l.Denials()
+	assert.Len(t, got, 2)
+	assert.Equal(t, "http://3.example.com", got[0].Origin)
+	assert.Equal(t, "http://2.example.com", got[1].Origin)
+}
+
+func TestDenialLog_Handler(t *testing.T) {
+	l := NewDenialLog(10)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		DenialLog:   l,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+	f.Get("/_cors/denials", l.Handler())
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://evil.com")
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	t.Run("json", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/_cors/denials", nil)
+		assert.Nil(t, err)
+
+		f.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "application/json; charset=utf-8", resp.Header().Get("Content-Type"))
+
+		var got []Denial
+		assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &got))
+		assert.Len(t, got, 1)
+		assert.Equal(t, "http://evil.com", got[0].Origin)
+		assert.Equal(t, http.MethodGet, got[0].Method)
+		assert.Equal(t, "/", got[0].Path)
+		assert.Equal(t, "origin host evil.com did not match allowlist entries [example.com]; AllowSubdomain=false", got[0].Reason)
+		assert.False(t, got[0].Time.IsZero())
+	})
+
+	t.Run("html", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/_cors/denials", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+		f.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+		assert.Contains(t, resp.Body.String(), "<td>http://evil.com</td>")
+	})
+}
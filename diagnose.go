@@ -0,0 +1,87 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/flamego/flamego"
+)
+
+// diagnosis is a probable misconfiguration of a response that browsers will
+// block.
+type diagnosis struct {
+	problem string
+	hint    string
+}
+
+// diagnose returns the probable misconfiguration of the response to the
+// cross-origin request, or nil if browsers will accept it.
+func diagnose(r *http.Request, header http.Header, origin string) *diagnosis {
+	allowOrigin := header.Get(HeaderAccessControlAllowOrigin)
+	switch {
+	case allowOrigin == "":
+		return &diagnosis{
+			problem: "Response to a cross-origin request has no Access-Control-Allow-Origin header, browsers will block it",
+			hint:    "Make sure the route is not exempted and the handler does not remove the CORS headers, or that the CORS middleware is enabled for it.",
+		}
+	case allowOrigin != "*" && allowOrigin != origin:
+		return &diagnosis{
+			problem: "Access-Control-Allow-Origin of the response does not match the request origin, browsers will block it",
+			hint:    `Make sure the handler does not overwrite the header and caches vary on "Origin", and that Scheme does not rewrite the allowed origin.`,
+		}
+	}
+
+	credentialed := r.Method != http.MethodOptions &&
+		(r.Header.Get("Cookie") != "" || r.Header.Get("Authorization") != "")
+	if !credentialed {
+		return nil
+	}
+	if allowOrigin == "*" {
+		return &diagnosis{
+			problem: "Credentialed request was answered with the wildcard origin, browsers will block it if credentials are included",
+			hint:    "Allow specific domains in AllowDomain and set AllowCredentials.",
+		}
+	}
+	if header.Get(HeaderAccessControlAllowCredentials) != "true" {
+		return &diagnosis{
+			problem: "Credentialed request was answered without Access-Control-Allow-Credentials, browsers will block it if credentials are included",
+			hint:    "Set AllowCredentials if the requesting page sends cookies or authorization.",
+		}
+	}
+	return nil
+}
+
+// registerDiagnosis logs the probable misconfiguration of the response to the
+// cross-origin request once the response is written.
+func registerDiagnosis(ctx flamego.Context, logger *log.Logger, origin string) {
+	if origin == "" {
+		return
+	}
+	// Same-origin requests send the "Origin" header for methods other than GET
+	// and HEAD, but are not subject to CORS.
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, ctx.Request().Host) {
+		return
+	}
+
+	ctx.ResponseWriter().Before(func(w flamego.ResponseWriter) {
+		if d, ok := DecisionOf(ctx); ok && d.Reason != "" {
+			// Denials are logged with their own reasons when Diagnose is set
+			return
+		}
+		if d := diagnose(ctx.Request().Request, w.Header(), origin); d != nil {
+			logger.WithPrefix("cors").Warn(d.problem,
+				"method", ctx.Request().Method,
+				"path", ctx.Request().RequestURI,
+				"origin", origin,
+				"hint", d.hint,
+			)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name        string
+		opt         Options
+		handler     flamego.Handler
+		origin      string
+		reqHeaders  map[string]string
+		wantProblem string
+	}{
+		{
+			name:   "allowed",
+			opt:    Options{AllowDomain: []string{"example.com"}, AllowCredentials: true},
+			origin: "http://example.com",
+			reqHeaders: map[string]string{
+				"Cookie": "session=1",
+			},
+		},
+		{
+			name:        "skipped",
+			opt:         Options{AllowDomain: []string{"example.com"}},
+			handler:     Skip,
+			origin:      "http://example.com",
+			wantProblem: "Response to a cross-origin request has no Access-Control-Allow-Origin header",
+		},
+		{
+			name: "overwritten",
+			opt:  Options{AllowDomain: []string{"example.com"}},
+			handler: func(c flamego.Context) {
+				c.ResponseWriter().Header().Set(HeaderAccessControlAllowOrigin, "http://example.org")
+			},
+			origin:      "http://example.com",
+			wantProblem: "Access-Control-Allow-Origin of the response does not match the request origin",
+		},
+		{
+			name:   "credentials under wildcard",
+			opt:    Options{},
+			origin: "http://example.com",
+			reqHeaders: map[string]string{
+				"Authorization": "Bearer token",
+			},
+			wantProblem: "Credentialed request was answered with the wildcard origin",
+		},
+		{
+			name:   "credentials not allowed",
+			opt:    Options{AllowDomain: []string{"example.com"}},
+			origin: "http://example.com",
+			reqHeaders: map[string]string{
+				"Cookie": "session=1",
+			},
+			wantProblem: "Credentialed request was answered without Access-Control-Allow-Credentials",
+		},
+		{
+			name: "disabled",
+			opt: Options{
+				AllowDomain: []string{"example.com"},
+				Flags:       NewMemoryFlags(),
+				Flag:        "cors",
+			},
+			origin:      "http://example.com",
+			wantProblem: "Response to a cross-origin request has no Access-Control-Allow-Origin header",
+		},
+		{
+			name:   "denied",
+			opt:    Options{AllowDomain: []string{"example.com"}},
+			origin: "http://example.org",
+		},
+		{
+			name: "same-origin",
+			opt:  Options{AllowDomain: []string{"example.com"}, Flags: NewMemoryFlags(), Flag: "cors"},
+			reqHeaders: map[string]string{
+				"Origin": "http://api.example.com",
+			},
+		},
+		{
+			name: "non-CORS request",
+			opt:  Options{AllowDomain: []string{"example.com"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			f := flamego.NewWithLogger(&buf)
+			test.opt.Diagnose = true
+			f.Use(CORS(test.opt))
+			handlers := []flamego.Handler{func() string { return responseBody }}
+			if test.handler != nil {
+				handlers = append([]flamego.Handler{test.handler}, handlers...)
+			}
+			f.Get("/", handlers...)
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+			assert.Nil(t, err)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			for k, v := range test.reqHeaders {
+				req.Header.Set(k, v)
+			}
+
+			f.ServeHTTP(resp, req)
+			if test.wantProblem == "" {
+				assert.NotContains(t, buf.String(), "browsers will block")
+				return
+			}
+			assert.Contains(t, buf.String(), test.wantProblem)
+		})
+	}
+}
+
+func TestDiagnose_Denied(t *testing.T) {
+	defer flamego.SetEnv(flamego.Env())
+	flamego.SetEnv(flamego.EnvTypeProd)
+
+	var buf bytes.Buffer
+	f := flamego.NewWithLogger(&buf)
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		Diagnose:    true,
+	}))
+	f.Get("/", func() string { return responseBody })
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.org")
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, buf.String(), "Denied CORS request")
+	assert.Contains(t, buf.String(), "example.org")
+}
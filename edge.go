@@ -0,0 +1,305 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// edgePolicy is the effective policy of the options in the terms of edge
+// proxies.
+type edgePolicy struct {
+	opt Options
+	// wildcard is true when any domain is allowed with the "*" wildcard.
+	wildcard bool
+	// timing is true when any origin may read the timing data.
+	timing bool
+	// origins is the regular expression that matches allowed origins.
+	origins string
+	methods string
+	// headers is empty when the requested headers are reflected.
+	headers string
+	expose  string
+	maxAge  string
+}
+
+// newEdgePolicy returns the edge policy of the options, or an error if the
+// options use features that are evaluated per request by the middleware or
+// that edge configuration does not render, so that it never silently differs
+// from the middleware.
+func newEdgePolicy(opt Options) (*edgePolicy, error) {
+	opt = prepareOptions([]Options{opt})
+
+	var unsupported []string
+	for name, used := range map[string]bool{
+		"RequireSecureOrigin":          opt.RequireSecureOrigin,
+		"CheckReferer":                 opt.CheckReferer,
+		"AllowOriginFunc":              opt.AllowOriginFunc != nil,
+		"TXTAllowlist":                 opt.TXTAllowlist != nil,
+		"AllowClientIP":                opt.AllowClientIP != nil,
+		"AllowClientCertificate":       opt.AllowClientCertificate != nil,
+		"Expires":                      len(opt.Expires) > 0,
+		"Windows":                      len(opt.Windows) > 0,
+		"NormalizeOrigin":              opt.NormalizeOrigin != nil,
+		"OriginHeader":                 opt.OriginHeader != "",
+		"Canary":                       len(opt.Canary) > 0,
+		"Flag":                         opt.Flag != "" || len(opt.FlagDomains) > 0,
+		"Quota":                        opt.Quota > 0,
+		"AllowFetchDest":               len(opt.AllowFetchDest) > 0,
+		"AlwaysAllowOrigin":            opt.AlwaysAllowOrigin,
+		"DecorateStatus":               len(opt.DecorateStatus) > 0,
+		"SkipStatus":                   len(opt.SkipStatus) > 0,
+		"ExemptPaths":                  len(opt.ExemptPaths) > 0,
+		"Listeners":                    len(opt.Listeners) > 0,
+		"TimingAllowOrigin":            len(opt.TimingAllowOrigin) > 0 && !contains(opt.TimingAllowOrigin, "*"),
+		"CDNSafe":                      opt.CDNSafe,
+		"VaryHeaders":                  len(opt.VaryHeaders) > 0,
+		"PrivateCache":                 opt.PrivateCache,
+		"ContentSecurityPolicy":        opt.ContentSecurityPolicy,
+		"OriginAgentCluster":           opt.OriginAgentCluster,
+		"PermittedCrossDomainPolicies": opt.PermittedCrossDomainPolicies != "",
+		"AllowSameHost":                opt.AllowSameHost,
+		"SunsetNotice":                 opt.SunsetNotice > 0,
+		"Schemes":                      len(opt.Schemes) > 0 && allowAnyDomain(opt),
+	} {
+		if used {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, errors.Errorf("%s cannot be expressed in edge configuration", strings.Join(unsupported, ", "))
+	}
+
+	p := &edgePolicy{
+		opt:      opt,
+		wildcard: allowAnyDomain(opt),
+		timing:   contains(opt.TimingAllowOrigin, "*"),
+		methods:  strings.Join(opt.Methods, ","),
+		headers:  strings.Join(allowHeaders(opt), ","),
+		expose:   strings.Join(exposeHeaders(opt), ","),
+		maxAge:   strconv.FormatFloat(opt.MaxAge.Seconds(), 'f', 0, 64),
+	}
+	if p.wildcard {
+		return p, nil
+	}
+
+	var alternatives []string
+	for _, o := range allowedOrigins(opt) {
+		re, err := domainRegexp(o.domain)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, regexp.QuoteMeta(o.scheme+"://")+re)
+	}
+	for _, pattern := range opt.AllowOriginPatterns {
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) == 0 {
+		// Nothing is allowed, which never matches
+		alternatives = []string{"$."}
+	}
+	p.origins = "^(" + strings.Join(alternatives, "|") + ")$"
+	return p, nil
+}
+
+// domainRegexp returns the regular expression that matches the hosts of the
+// allowed domain.
+func domainRegexp(domain string) (string, error) {
+	if domain == "*" {
+		return `[^/]+`, nil
+	}
+
+	_, port, err := net.SplitHostPort(domain)
+	if err != nil {
+		return hostRegexp(domain), nil
+	}
+	switch {
+	case port == "*":
+		// The default port of the scheme is sent without a port
+		return hostRegexp(strings.TrimSuffix(domain, ":*")) + `(?::[0-9]+)?`, nil
+	case strings.Contains(port, "-"):
+		return "", errors.Errorf("port range of %q cannot be expressed in edge configuration", domain)
+	}
+	return hostRegexp(domain), nil
+}
+
+// hostRegexp returns the regular expression that matches the host, which may
+// include a port, where a leading "*" label matches one or more labels and
+// any other "*" label matches exactly one.
+func hostRegexp(host string) string {
+	var prefix string
+	if strings.HasPrefix(host, "*.") {
+		prefix = `(?:[A-Za-z0-9-]+\.)+`
+		host = strings.TrimPrefix(host, "*.")
+	}
+	labels := strings.Split(host, ".")
+	for i, l := range labels {
+		if l == "*" {
+			labels[i] = `[A-Za-z0-9-]+`
+			continue
+		}
+		labels[i] = regexp.QuoteMeta(l)
+	}
+	return prefix + strings.Join(labels, `\.`)
+}
+
+// vary returns the value of the "Vary" header of responses, which preflight
+// responses extend with the request headers that they depend on, as sent by
+// the middleware.
+func (p *edgePolicy) vary(preflight bool) string {
+	var vary []string
+	if !p.wildcard {
+		vary = append(vary, HeaderOrigin)
+	}
+	if preflight {
+		vary = append(vary, HeaderAccessControlRequestMethod, HeaderAccessControlRequestHeaders)
+	}
+	return strings.Join(vary, ",")
+}
+
+// NginxConfig returns nginx configuration that enforces the same CORS policy
+// as the options, for teams moving enforcement to the edge. The "map" blocks
+// belong in the "http" block and the rest in the "location" block. Unlike the
+// middleware, prohibited requests are passed through without CORS headers.
+// It returns an error if the options use features that are evaluated per
+// request or not rendered, e.g. Canary, AllowClientIP or CDNSafe.
+func (opt Options) NginxConfig() (string, error) {
+	p, err := newEdgePolicy(opt)
+	if err != nil {
+		return "", err
+	}
+
+	allowHeaders := "$http_access_control_request_headers"
+	if p.headers != "" {
+		allowHeaders = fmt.Sprintf("%q", p.headers)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by github.com/flamego/cors, do not edit.\n\n")
+	buf.WriteString("# In the http block:\n")
+	allowOrigin := `"*"`
+	if !p.wildcard {
+		allowOrigin = "$cors_allow_origin"
+		buf.WriteString("map $http_origin $cors_allow_origin {\n")
+		buf.WriteString("    default \"\";\n")
+		fmt.Fprintf(&buf, "    \"~%s\" $http_origin;\n", p.origins)
+		buf.WriteString("}\n")
+	}
+	buf.WriteString("map \"$request_method:" + strings.Trim(allowOrigin, `"`) + "\" $cors_preflight {\n")
+	buf.WriteString("    default 0;\n")
+	buf.WriteString("    \"~^OPTIONS:.+\" 1;\n")
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("# In the location block:\n")
+	writeHeaders := func(indent string, preflight bool) {
+		add := func(name, value string) {
+			fmt.Fprintf(&buf, "%sadd_header %s %s always;\n", indent, name, value)
+		}
+		add(HeaderAccessControlAllowOrigin, allowOrigin)
+		if vary := p.vary(preflight); vary != "" {
+			add("Vary", vary)
+		}
+		if !p.wildcard && p.opt.AllowCredentials {
+			add(HeaderAccessControlAllowCredentials, "true")
+		}
+		if !p.opt.StrictDefaults || preflight {
+			add(HeaderAccessControlAllowMethods, fmt.Sprintf("%q", p.methods))
+			add(HeaderAccessControlAllowHeaders, allowHeaders)
+			add(HeaderAccessControlMaxAge, p.maxAge)
+		}
+		if p.expose != "" && !preflight {
+			add(HeaderAccessControlExposeHeaders, fmt.Sprintf("%q", p.expose))
+		}
+		if p.timing && !preflight {
+			add(HeaderTimingAllowOrigin, `"*"`)
+		}
+	}
+	writeHeaders("", false)
+	buf.WriteString("if ($cors_preflight) {\n")
+	writeHeaders("    ", true)
+	if !p.opt.OptionsPassthrough {
+		fmt.Fprintf(&buf, "    return %d;\n", p.opt.PreflightStatus)
+	}
+	buf.WriteString("}\n")
+	return buf.String(), nil
+}
+
+// CaddyConfig returns a Caddyfile snippet that enforces the same CORS policy
+// as the options, for teams moving enforcement to the edge. It belongs in a
+// site block. Unlike the middleware, prohibited requests are passed through
+// without CORS headers. It returns an error if the options use features that
+// are evaluated per request or not rendered, e.g. Canary, AllowClientIP or
+// CDNSafe.
+func (opt Options) CaddyConfig() (string, error) {
+	p, err := newEdgePolicy(opt)
+	if err != nil {
+		return "", err
+	}
+
+	allowHeaders := "{header.Access-Control-Request-Headers}"
+	if p.headers != "" {
+		allowHeaders = fmt.Sprintf("%q", p.headers)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by github.com/flamego/cors, do not edit.\n")
+	matcher := "header Origin *"
+	allowOrigin := `"*"`
+	if !p.wildcard {
+		// Backticks quote tokens without escapes in Caddyfiles
+		matcher = "header_regexp Origin `" + p.origins + "`"
+		allowOrigin = "{header.Origin}"
+	}
+	fmt.Fprintf(&buf, "@cors_origin %s\n", matcher)
+	buf.WriteString("@cors_preflight {\n")
+	buf.WriteString("\tmethod OPTIONS\n")
+	fmt.Fprintf(&buf, "\t%s\n", matcher)
+	buf.WriteString("}\n")
+	if !p.wildcard {
+		// Responses depend on the origin whether they are decorated or not
+		buf.WriteString("header Vary Origin\n")
+	}
+
+	writeHeaders := func(name string, preflight bool) {
+		fmt.Fprintf(&buf, "header %s {\n", name)
+		add := func(name, value string) {
+			fmt.Fprintf(&buf, "\t%s %s\n", name, value)
+		}
+		add(HeaderAccessControlAllowOrigin, allowOrigin)
+		if preflight {
+			add("Vary", p.vary(true))
+		}
+		if !p.wildcard && p.opt.AllowCredentials {
+			add(HeaderAccessControlAllowCredentials, "true")
+		}
+		if !p.opt.StrictDefaults || preflight {
+			add(HeaderAccessControlAllowMethods, fmt.Sprintf("%q", p.methods))
+			add(HeaderAccessControlAllowHeaders, allowHeaders)
+			add(HeaderAccessControlMaxAge, p.maxAge)
+		}
+		if p.expose != "" && !preflight {
+			add(HeaderAccessControlExposeHeaders, fmt.Sprintf("%q", p.expose))
+		}
+		if p.timing && !preflight {
+			add(HeaderTimingAllowOrigin, `"*"`)
+		}
+		buf.WriteString("}\n")
+	}
+	writeHeaders("@cors_origin", false)
+	writeHeaders("@cors_preflight", true)
+	if !p.opt.OptionsPassthrough {
+		fmt.Fprintf(&buf, "respond @cors_preflight %d\n", p.opt.PreflightStatus)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/cors/policy"
+)
+
+func TestEdgePolicy_Origins(t *testing.T) {
+	p, err := newEdgePolicy(Options{
+		Scheme:         "https",
+		AllowDomain:    []string{"example.com", "localhost:*"},
+		AllowSubdomain: true,
+	})
+	assert.Nil(t, err)
+	re := regexp.MustCompile(p.origins)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://example.com", want: true},
+		{origin: "https://a.b.example.com", want: true},
+		{origin: "https://localhost:3000", want: true},
+		{origin: "http://example.com", want: false},
+		{origin: "https://example.com:8443", want: false},
+		{origin: "https://evilexample.com", want: false},
+		{origin: "https://example.com.evil.com", want: false},
+		{origin: "https://localhost", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			assert.Equal(t, test.want, re.MatchString(test.origin))
+		})
+	}
+}
+
+func TestEdgePolicy_WildcardLabels(t *testing.T) {
+	p, err := newEdgePolicy(Options{
+		Scheme:      "https",
+		AllowDomain: []string{"*.example.com", "api.*.example.org:*"},
+	})
+	assert.Nil(t, err)
+	re := regexp.MustCompile(p.origins)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://a.example.com", want: true},
+		{origin: "https://a.b.example.com", want: true},
+		{origin: "https://example.com", want: false},
+		{origin: "https://a.example.com.evil.com", want: false},
+		{origin: "https://api.eu.example.org:8443", want: true},
+		{origin: "https://api.a.eu.example.org:8443", want: false},
+		{origin: "https://api.eu.example.org", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.origin, func(t *testing.T) {
+			assert.Equal(t, test.want, re.MatchString(test.origin))
+			assert.Equal(t, test.want, policy.New(policyConfig(p.opt)).Evaluate(policy.Request{Origin: test.origin}).Denial == nil)
+		})
+	}
+}
+
+func TestEdgePolicy_OriginPatterns(t *testing.T) {
+	p, err := newEdgePolicy(Options{
+		AllowOriginPatterns: []string{`https://pr-\d+\.preview\.example\.com`},
+	})
+	assert.Nil(t, err)
+	assert.False(t, p.wildcard)
+	re := regexp.MustCompile(p.origins)
+
+	assert.True(t, re.MatchString("https://pr-42.preview.example.com"))
+	assert.False(t, re.MatchString("https://pr-42.preview.example.com.evil.com"))
+	assert.False(t, re.MatchString("https://example.com"))
+}
+
+func TestOptions_NginxConfig(t *testing.T) {
+	got, err := Options{
+		Scheme:           "https",
+		AllowDomain:      []string{"example.com"},
+		AllowHeaders:     []string{"X-Token"},
+		AllowCredentials: true,
+		StrictDefaults:   true,
+		MaxAge:           time.Hour,
+	}.NginxConfig()
+	assert.Nil(t, err)
+
+	want := `# Generated by github.com/flamego/cors, do not edit.
+
+# In the http block:
+map $http_origin $cors_allow_origin {
+    default "";
+    "~^(https://example\.com)$" $http_origin;
+}
+map "$request_method:$cors_allow_origin" $cors_preflight {
+    default 0;
+    "~^OPTIONS:.+" 1;
+}
+
+# In the location block:
+add_header Access-Control-Allow-Origin $cors_allow_origin always;
+add_header Vary Origin always;
+add_header Access-Control-Allow-Credentials true always;
+if ($cors_preflight) {
+    add_header Access-Control-Allow-Origin $cors_allow_origin always;
+    add_header Vary Origin,Access-Control-Request-Method,Access-Control-Request-Headers always;
+    add_header Access-Control-Allow-Credentials true always;
+    add_header Access-Control-Allow-Methods "GET,HEAD,POST" always;
+    add_header Access-Control-Allow-Headers "Accept,Accept-Language,Content-Language,Content-Type,X-Token" always;
+    add_header Access-Control-Max-Age 3600 always;
+    return 204;
+}
+`
+	assert.Equal(t, want, got)
+}
+
+func TestOptions_CaddyConfig(t *testing.T) {
+	got, err := Options{
+		ExposeTrailers: []string{"Server-Timing"},
+	}.CaddyConfig()
+	assert.Nil(t, err)
+
+	want := `# Generated by github.com/flamego/cors, do not edit.
+@cors_origin header Origin *
+@cors_preflight {
+	method OPTIONS
+	header Origin *
+}
+header @cors_origin {
+	Access-Control-Allow-Origin "*"
+	Access-Control-Allow-Methods "GET,OPTIONS,POST"
+	Access-Control-Allow-Headers {header.Access-Control-Request-Headers}
+	Access-Control-Max-Age 600
+	Access-Control-Expose-Headers "Server-Timing"
+}
+header @cors_preflight {
+	Access-Control-Allow-Origin "*"
+	Vary Access-Control-Request-Method,Access-Control-Request-Headers
+	Access-Control-Allow-Methods "GET,OPTIONS,POST"
+	Access-Control-Allow-Headers {header.Access-Control-Request-Headers}
+	Access-Control-Max-Age 600
+}
+respond @cors_preflight 204
+`
+	assert.Equal(t, want, got)
+
+	// Every response varies on the origin with an allowlist
+	got, err = Options{AllowDomain: []string{"example.com"}}.CaddyConfig()
+	assert.Nil(t, err)
+	assert.Contains(t, got, "}\nheader Vary Origin\nheader @cors_origin {")
+	assert.Contains(t, got, "\tVary Origin,Access-Control-Request-Method,Access-Control-Request-Headers\n")
+}
+
+func TestEdgeConfig_TimingAllowOrigin(t *testing.T) {
+	opt := Options{TimingAllowOrigin: []string{"*"}}
+
+	got, err := opt.NginxConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(got, `add_header Timing-Allow-Origin "*" always;`))
+
+	got, err = opt.CaddyConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(got, `Timing-Allow-Origin "*"`))
+}
+
+func TestEdgeConfig_Unsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     Options
+		wantErr string
+	}{
+		{
+			name:    "per-request features",
+			opt:     Options{CheckReferer: true, Canary: map[string]int{"partner.com": 10}},
+			wantErr: "Canary, CheckReferer cannot be expressed in edge configuration",
+		},
+		{
+			name:    "response decorations",
+			opt:     Options{CDNSafe: true, PrivateCache: true, VaryHeaders: []string{"X-Tenant"}, SunsetNotice: time.Hour},
+			wantErr: "CDNSafe, PrivateCache, SunsetNotice, VaryHeaders cannot be expressed in edge configuration",
+		},
+		{
+			name:    "wildcard with schemes",
+			opt:     Options{Schemes: []string{"https", "capacitor"}},
+			wantErr: "Schemes cannot be expressed in edge configuration",
+		},
+		{
+			name:    "per-origin timing",
+			opt:     Options{TimingAllowOrigin: []string{"https://example.com"}},
+			wantErr: "TimingAllowOrigin cannot be expressed in edge configuration",
+		},
+		{
+			name:    "port range",
+			opt:     Options{AllowDomain: []string{"localhost:3000-3999"}},
+			wantErr: `port range of "localhost:3000-3999" cannot be expressed in edge configuration`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.opt.NginxConfig()
+			assert.EqualError(t, err, test.wantErr)
+			_, err = test.opt.CaddyConfig()
+			assert.EqualError(t, err, test.wantErr)
+		})
+	}
+}
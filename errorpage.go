@@ -0,0 +1,46 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// errorPage contains data to render the debug error page of a denial.
+type errorPage struct {
+	Message string
+	Origin  string
+	Reason  string
+	Hint    string
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CORS request denied</title>
+</head>
+<body>
+<h1>CORS request denied</h1>
+<p>{{.Message}}</p>
+<dl>
+<dt>Origin</dt><dd><code>{{.Origin}}</code></dd>
+<dt>Failed check</dt><dd><code>{{.Reason}}</code></dd>
+<dt>How to fix</dt><dd>{{.Hint}}</dd>
+</dl>
+<p>This page is only shown with DebugErrorPage.</p>
+</body>
+</html>
+`))
+
+// writeErrorPage writes the debug error page of a denial with the given
+// status code.
+func writeErrorPage(w http.ResponseWriter, code int, page errorPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_ = errorPageTemplate.Execute(w, page)
+}
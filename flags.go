@@ -0,0 +1,66 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"context"
+	"sync"
+)
+
+// FlagProvider is a feature flag system, such as LaunchDarkly or Unleash, that
+// is consulted on every request to toggle origins or the whole middleware
+// without redeploys. Implementations must be safe for concurrent use.
+type FlagProvider interface {
+	// Enabled returns true if the flag is enabled, the context is the context of
+	// the request.
+	Enabled(ctx context.Context, flag string) bool
+}
+
+// flagGates returns the gates of domains that are toggled by flags.
+func flagGates(flags FlagProvider, domains map[string]string) map[string]func(ctx context.Context) bool {
+	if len(domains) == 0 {
+		return nil
+	}
+
+	gates := make(map[string]func(ctx context.Context) bool, len(domains))
+	for domain, flag := range domains {
+		flag := flag
+		gates[domain] = func(ctx context.Context) bool { return flags.Enabled(ctx, flag) }
+	}
+	return gates
+}
+
+// MemoryFlags is an in-memory FlagProvider, it is safe for concurrent use.
+type MemoryFlags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+var _ FlagProvider = (*MemoryFlags)(nil)
+
+// NewMemoryFlags returns a new MemoryFlags with the given flags enabled.
+func NewMemoryFlags(enabled ...string) *MemoryFlags {
+	flags := make(map[string]bool, len(enabled))
+	for _, flag := range enabled {
+		flags[flag] = true
+	}
+	return &MemoryFlags{
+		flags: flags,
+	}
+}
+
+// Enabled returns true if the flag is enabled.
+func (f *MemoryFlags) Enabled(_ context.Context, flag string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags[flag]
+}
+
+// Set enables or disables the flag.
+func (f *MemoryFlags) Set(flag string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flags[flag] = enabled
+}
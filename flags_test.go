@@ -0,0 +1,75 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestMemoryFlags(t *testing.T) {
+	flags := NewMemoryFlags("a")
+	assert.True(t, flags.Enabled(context.Background(), "a"))
+	assert.False(t, flags.Enabled(context.Background(), "b"))
+
+	flags.Set("a", false)
+	flags.Set("b", true)
+	assert.False(t, flags.Enabled(context.Background(), "a"))
+	assert.True(t, flags.Enabled(context.Background(), "b"))
+}
+
+func TestFlags(t *testing.T) {
+	flags := NewMemoryFlags("cors")
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		Flags:       flags,
+		Flag:        "cors",
+		FlagDomains: map[string]string{
+			"partner.com": "cors-partner",
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	do := func(origin string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", origin)
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := do("http://partner.com")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "CORS request from prohibited domain http://partner.com\n", resp.Body.String())
+
+	flags.Set("cors-partner", true)
+	resp = do("http://partner.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "http://partner.com", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	// Disabling the middleware lets any request through untouched
+	flags.Set("cors", false)
+	resp = do("http://evil.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, responseBody, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestFlags_Missing(t *testing.T) {
+	assert.PanicsWithValue(t, "cors: Flags must be set to use Flag or FlagDomains", func() {
+		CORS(Options{Flag: "cors"})
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/flamego/cors/policy"
+)
+
+// Names of the CORS request and response headers.
+const (
+	HeaderOrigin                        = "Origin"
+	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
+	HeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HeaderAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
+	HeaderTimingAllowOrigin             = "Timing-Allow-Origin"
+)
+
+// SafelistedHeaders is the list of CORS-safelisted request headers of the Fetch
+// standard, which is the baseline of Options.AllowHeaders. "Content-Type" is
+// only safelisted by browsers for form values, so it is allowed explicitly for
+// e.g. JSON requests.
+var SafelistedHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"Content-Language",
+	"Content-Type",
+}
+
+// addVary adds the names to the "Vary" header that are not listed yet, keeping
+// the entries that were set before, e.g. by compression middleware. It returns
+// the names that were added.
+func addVary(header http.Header, names ...string) (added []string) {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" {
+				// Varies on everything already
+				return nil
+			}
+			if f != "" {
+				vary = append(vary, f)
+			}
+		}
+	}
+	for _, name := range names {
+		if !policy.HasHeader(vary, name) {
+			vary = append(vary, name)
+			added = append(added, name)
+		}
+	}
+	header.Set("Vary", strings.Join(vary, ","))
+	return added
+}
+
+// addedVary is the list of entries of the "Vary" header that the middleware
+// added to the response, which is injected into the request context for Skip.
+type addedVary struct {
+	names []string
+}
+
+// add adds the names to the "Vary" header like addVary, and records those that
+// were added.
+func (v *addedVary) add(header http.Header, names ...string) {
+	v.names = append(v.names, addVary(header, names...)...)
+}
+
+// removeVary removes the names from the "Vary" header, keeping the other
+// entries joined like addVary does and deleting the header if none are left.
+func removeVary(header http.Header, names ...string) {
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f != "" && !policy.HasHeader(names, f) && !policy.HasHeader(vary, f) {
+				vary = append(vary, f)
+			}
+		}
+	}
+	if len(vary) > 0 {
+		header.Set("Vary", strings.Join(vary, ","))
+	} else {
+		header.Del("Vary")
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net"
+	"net/http"
+)
+
+// localAddr returns the local address of the listener that accepted the
+// request, or an empty string if unknown.
+func localAddr(r *http.Request) string {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return ""
+	}
+	return addr.String()
+}
+
+// matchListener returns the handler of the listener of the request, or nil if
+// no listener matches. Keys are matched on the whole address first, then on
+// the port alone, e.g. ":8081" matches any local address with the port 8081.
+func (h *handler) matchListener(r *http.Request) *handler {
+	var name string
+	if h.opt.Listener != nil {
+		name = h.opt.Listener(r)
+	} else {
+		name = localAddr(r)
+	}
+	if name == "" {
+		return nil
+	}
+
+	if lh, ok := h.listeners[name]; ok {
+		return lh
+	}
+	if _, port, err := net.SplitHostPort(name); err == nil {
+		if lh, ok := h.listeners[":"+port]; ok {
+			return lh
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestListeners(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      Options
+		addr     net.Addr
+		wantCode int
+	}{
+		{
+			name:     "public",
+			opt:      Options{AllowDomain: []string{"example.com"}, Listeners: map[string]*Options{":8081": {AllowDomain: []string{"!*"}}}},
+			addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "internal port",
+			opt:      Options{AllowDomain: []string{"example.com"}, Listeners: map[string]*Options{":8081": {AllowDomain: []string{"!*"}}}},
+			addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8081},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "internal address",
+			opt:      Options{AllowDomain: []string{"example.com"}, Listeners: map[string]*Options{"10.0.0.1:8080": {AllowDomain: []string{"!*"}}}},
+			addr:     &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "unknown address",
+			opt:      Options{AllowDomain: []string{"example.com"}, Listeners: map[string]*Options{":8081": {AllowDomain: []string{"!*"}}}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "hook",
+			opt: Options{
+				AllowDomain: []string{"example.com"},
+				Listeners:   map[string]*Options{"internal": {AllowDomain: []string{"!*"}}},
+				Listener: func(r *http.Request) string {
+					return r.Header.Get("X-Listener")
+				},
+			},
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", func(c flamego.Context) string {
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			if test.addr != nil {
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, test.addr))
+			}
+			req.Header.Set("Origin", "http://example.org")
+			req.Header.Set("X-Listener", "internal")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/flamego/flamego"
+)
+
+// ManifestPath is the well-known path where a CORS manifest is served.
+const ManifestPath = "/.well-known/cors.json"
+
+// ManifestVersion is the version of the manifest schema.
+const ManifestVersion = 1
+
+// Manifest is the published CORS policy of a service, served as JSON at
+// ManifestPath so that other services can discover it.
+type Manifest struct {
+	// Version is the version of the manifest schema, see ManifestVersion.
+	Version int `json:"version"`
+	// Origins is the list of allowed origins, in the form of
+	// "<scheme>://<domain>", where the domain may be prefixed with "*." to allow
+	// any subdomain, or the single "*" wildcard to allow any origin.
+	Origins []string `json:"origins"`
+	// Methods is the list of allowed methods.
+	Methods []string `json:"methods"`
+	// AllowCredentials indicates whether requests with credentials are allowed.
+	AllowCredentials bool `json:"allow_credentials"`
+	// MaxAge is the number of seconds for which preflight responses are cached.
+	MaxAge int `json:"max_age"`
+}
+
+// NewManifest returns the manifest of the effective options.
+func NewManifest(options ...Options) *Manifest {
+	opt := prepareOptions(options)
+
+	m := &Manifest{
+		Version:          ManifestVersion,
+		Origins:          []string{},
+		Methods:          opt.Methods,
+		AllowCredentials: opt.AllowCredentials,
+		MaxAge:           int(opt.MaxAge.Seconds()),
+	}
+	seen := make(map[string]bool)
+	for _, o := range allowedOrigins(opt) {
+		origin := o.scheme + "://" + o.domain
+		if o.domain == "*" {
+			origin = "*"
+		}
+		if seen[origin] {
+			continue
+		}
+		seen[origin] = true
+		m.Origins = append(m.Origins, origin)
+	}
+	return m
+}
+
+// Options returns the options that allow the origins of the manifest.
+// Subdomain entries are kept as wildcard patterns, e.g. "*.example.com".
+func (m *Manifest) Options() Options {
+	opt := Options{
+		Methods:          m.Methods,
+		AllowCredentials: m.AllowCredentials,
+	}
+	if m.MaxAge > 0 {
+		opt.MaxAge = time.Duration(m.MaxAge) * time.Second
+	}
+
+	for _, origin := range m.Origins {
+		if origin == "*" {
+			return Options{
+				AllowDomain: []string{"*"},
+				Methods:     opt.Methods,
+				MaxAge:      opt.MaxAge,
+			}
+		}
+
+		scheme, domain, ok := strings.Cut(origin, "://")
+		if !ok {
+			continue
+		}
+		if opt.SchemeDomains == nil {
+			opt.SchemeDomains = make(map[string][]string)
+		}
+		if !contains(opt.SchemeDomains[scheme], domain) {
+			opt.SchemeDomains[scheme] = append(opt.SchemeDomains[scheme], domain)
+		}
+	}
+	if opt.SchemeDomains == nil {
+		// Allow nothing rather than falling back to the default wildcard
+		opt.StrictDefaults = true
+	}
+	return opt
+}
+
+// contains returns true if the list contains the string.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ManifestJSON returns a handler that serves the manifest of the effective
+// options as JSON. It is meant to be mounted at ManifestPath. Responses carry
+// an "ETag" for conditional polling and are gzip-compressed when accepted.
+func ManifestJSON(options ...Options) flamego.Handler {
+	body, err := json.Marshal(NewManifest(options...))
+	if err != nil {
+		panic("cors: marshal manifest: " + err.Error())
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, _ = gw.Write(body)
+	_ = gw.Close()
+
+	return func(c flamego.Context) {
+		header := c.ResponseWriter().Header()
+		header.Set("ETag", etag)
+		addVary(header, "Accept-Encoding")
+		if c.Request().Header.Get("If-None-Match") == etag {
+			c.ResponseWriter().WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		header.Set("Content-Type", "application/json; charset=utf-8")
+		if strings.Contains(c.Request().Header.Get("Accept-Encoding"), "gzip") {
+			header.Set("Content-Encoding", "gzip")
+			c.ResponseWriter().WriteHeader(http.StatusOK)
+			_, _ = c.ResponseWriter().Write(gzipped.Bytes())
+			return
+		}
+		c.ResponseWriter().WriteHeader(http.StatusOK)
+		_, _ = c.ResponseWriter().Write(body)
+	}
+}
+
+// maxManifestSize is the maximum size in bytes of a decoded manifest.
+const maxManifestSize = 1 << 20
+
+// ManifestFetcher fetches the manifest of another service. It remembers the
+// last fetched manifest to poll with conditional requests, so that a manifest
+// that has not changed costs no transfer, and it is safe for concurrent use.
+type ManifestFetcher struct {
+	// URL is the URL of the manifest, e.g.
+	// "https://api.example.com/.well-known/cors.json".
+	URL string
+	// Client is the HTTP client to fetch the manifest with. Default is
+	// http.DefaultClient.
+	Client *http.Client
+
+	mu           sync.Mutex
+	manifest     *Manifest
+	etag         string
+	lastModified string
+	stats        ManifestFetchStats
+}
+
+// ManifestFetchStats is the metrics of a ManifestFetcher, e.g. to export the
+// not-modified ratio of polls.
+type ManifestFetchStats struct {
+	// Fetches is the number of successful fetches, including NotModified.
+	Fetches int
+	// NotModified is the number of fetches answered with "304 Not Modified".
+	NotModified int
+	// Bytes is the total number of bytes of response bodies as transferred,
+	// i.e. compressed.
+	Bytes int64
+	// LastSize is the size in bytes of the last fetched manifest as
+	// transferred.
+	LastSize int64
+}
+
+// NotModifiedRatio returns the ratio of fetches that were answered with "304
+// Not Modified", or 0 if there were no fetches.
+func (s ManifestFetchStats) NotModifiedRatio() float64 {
+	if s.Fetches == 0 {
+		return 0
+	}
+	return float64(s.NotModified) / float64(s.Fetches)
+}
+
+// Stats returns the metrics of the fetcher.
+func (f *ManifestFetcher) Stats() ManifestFetchStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// Fetch fetches and decodes the manifest. When the manifest has not been
+// modified since the last fetch, as told by its "ETag" or "Last-Modified"
+// header, the last fetched manifest is returned.
+func (f *ManifestFetcher) Fetch(ctx context.Context) (*Manifest, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Accept", "application/json")
+	// Requested explicitly so the transferred size is known, which also
+	// disables transparent decompression of the transport.
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	f.mu.Lock()
+	if f.manifest != nil {
+		if f.etag != "" {
+			req.Header.Set("If-None-Match", f.etag)
+		}
+		if f.lastModified != "" {
+			req.Header.Set("If-Modified-Since", f.lastModified)
+		}
+	}
+	f.mu.Unlock()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotModified {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.manifest == nil {
+			return nil, errors.New("not modified without a previous manifest")
+		}
+		f.stats.Fetches++
+		f.stats.NotModified++
+		return f.manifest.clone(), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	counter := &countingReader{r: resp.Body}
+	var body io.Reader = counter
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gr, err := gzip.NewReader(counter)
+		if err != nil {
+			return nil, errors.Wrap(err, "new gzip reader")
+		}
+		defer func() { _ = gr.Close() }()
+		body = gr
+	}
+
+	var m Manifest
+	err = json.NewDecoder(io.LimitReader(body, maxManifestSize)).Decode(&m)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode manifest")
+	}
+	if m.Version != ManifestVersion {
+		return nil, errors.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.manifest = m.clone()
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.stats.Fetches++
+	f.stats.Bytes += counter.n
+	f.stats.LastSize = counter.n
+	return &m, nil
+}
+
+// clone returns a deep copy of the manifest.
+func (m *Manifest) clone() *Manifest {
+	c := *m
+	c.Origins = cloneStrings(m.Origins)
+	c.Methods = cloneStrings(m.Methods)
+	return &c
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
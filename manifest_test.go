@@ -0,0 +1,258 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestNewManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Options
+		want *Manifest
+	}{
+		{
+			name: "defaults",
+			opt:  Options{},
+			want: &Manifest{
+				Version: ManifestVersion,
+				Origins: []string{"*"},
+				Methods: []string{http.MethodGet, http.MethodOptions, http.MethodPost},
+				MaxAge:  600,
+			},
+		},
+		{
+			name: "subdomains",
+			opt: Options{
+				Scheme:           "https",
+				AllowDomain:      []string{"example.com"},
+				AllowSubdomain:   true,
+				Methods:          []string{http.MethodGet},
+				MaxAge:           time.Minute,
+				AllowCredentials: true,
+			},
+			want: &Manifest{
+				Version:          ManifestVersion,
+				Origins:          []string{"https://example.com", "https://*.example.com"},
+				Methods:          []string{http.MethodGet},
+				AllowCredentials: true,
+				MaxAge:           60,
+			},
+		},
+		{
+			name: "nothing allowed",
+			opt:  Options{StrictDefaults: true},
+			want: &Manifest{
+				Version: ManifestVersion,
+				Origins: []string{},
+				Methods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+				MaxAge:  600,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, NewManifest(test.opt))
+		})
+	}
+}
+
+func TestManifest_Options(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest *Manifest
+		want     Options
+	}{
+		{
+			name:     "wildcard",
+			manifest: &Manifest{Origins: []string{"*"}},
+			want:     Options{AllowDomain: []string{"*"}},
+		},
+		{
+			name: "scheme domains",
+			manifest: &Manifest{
+				Origins:          []string{"https://example.com", "https://*.example.com", "http://localhost:3000"},
+				Methods:          []string{http.MethodGet},
+				AllowCredentials: true,
+				MaxAge:           60,
+			},
+			want: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com", "*.example.com"},
+					"http":  {"localhost:3000"},
+				},
+				Methods:          []string{http.MethodGet},
+				MaxAge:           time.Minute,
+				AllowCredentials: true,
+			},
+		},
+		{
+			name:     "nothing allowed",
+			manifest: &Manifest{Origins: []string{}},
+			want:     Options{StrictDefaults: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.manifest.Options())
+		})
+	}
+}
+
+func TestManifestFetcher(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Get(ManifestPath, ManifestJSON(Options{
+		Scheme:      "https",
+		AllowDomain: []string{"example.com"},
+	}))
+	f.Get("/bad-version", func() string {
+		return `{"version":2}`
+	})
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	t.Run("fetch", func(t *testing.T) {
+		fetcher := &ManifestFetcher{URL: server.URL + ManifestPath}
+		m, err := fetcher.Fetch(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com"}, m.Origins)
+
+		// The fetched allowlist is enforced by the consuming service
+		consumer := flamego.NewWithLogger(&bytes.Buffer{})
+		consumer.Use(CORS(m.Options()))
+		consumer.Get("/", func() string {
+			return responseBody
+		})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "https://example.com")
+		consumer.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "https://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+
+		resp = httptest.NewRecorder()
+		req.Header.Set("Origin", "http://example.com")
+		consumer.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		fetcher := &ManifestFetcher{URL: server.URL + "/404"}
+		_, err := fetcher.Fetch(context.Background())
+		assert.EqualError(t, err, "unexpected status code 404")
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		fetcher := &ManifestFetcher{URL: server.URL + "/bad-version"}
+		_, err := fetcher.Fetch(context.Background())
+		assert.EqualError(t, err, "unsupported manifest version 2")
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		fetcher := &ManifestFetcher{URL: server.URL + ManifestPath}
+		for i := 0; i < 3; i++ {
+			m, err := fetcher.Fetch(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"https://example.com"}, m.Origins)
+		}
+
+		stats := fetcher.Stats()
+		assert.Equal(t, 3, stats.Fetches)
+		assert.Equal(t, 2, stats.NotModified)
+		assert.InDelta(t, 2.0/3, stats.NotModifiedRatio(), 0.001)
+		// The manifest is transferred once, compressed
+		assert.Equal(t, stats.LastSize, stats.Bytes)
+		assert.NotZero(t, stats.Bytes)
+	})
+}
+
+func TestManifestFetcher_LastModified(t *testing.T) {
+	const lastModified = "Sat, 07 Mar 2026 00:00:00 GMT"
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte(`{"version":1,"origins":["https://example.com"],"methods":["GET"]}`))
+	}))
+	defer server.Close()
+
+	fetcher := &ManifestFetcher{URL: server.URL}
+	for i := 0; i < 2; i++ {
+		m, err := fetcher.Fetch(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com"}, m.Origins)
+	}
+	assert.Equal(t, 2, requests)
+
+	stats := fetcher.Stats()
+	assert.Equal(t, 1, stats.NotModified)
+	assert.Equal(t, int64(65), stats.Bytes)
+}
+
+func TestManifestJSON(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Get(ManifestPath, ManifestJSON(Options{AllowDomain: []string{"example.com"}}))
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, ManifestPath, nil)
+	assert.Nil(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	etag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	resp = httptest.NewRecorder()
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(resp.Body)
+	assert.Nil(t, err)
+	var m Manifest
+	assert.Nil(t, json.NewDecoder(gr).Decode(&m))
+	assert.Equal(t, []string{"http://example.com"}, m.Origins)
+
+	resp = httptest.NewRecorder()
+	req.Header.Set("If-None-Match", etag)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Empty(t, resp.Body.String())
+}
+
+func TestManifestJSON_CORS(t *testing.T) {
+	opt := Options{AllowDomain: []string{"example.com"}}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(opt))
+	f.Get(ManifestPath, ManifestJSON(opt))
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, ManifestPath, nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://example.com")
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "http://example.com", resp.Header().Get(HeaderAccessControlAllowOrigin))
+	assert.Equal(t, []string{"Origin,Accept-Encoding"}, resp.Header().Values("Vary"))
+}
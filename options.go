@@ -0,0 +1,241 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/flamego/cors/policy"
+)
+
+// redacted replaces the values of dynamic providers in the marshaled options,
+// which may hold credentials or connections.
+const redacted = "[redacted]"
+
+// optionsJSON is the JSON representation of the effective options.
+type optionsJSON struct {
+	Scheme                       string                  `json:"scheme"`
+	Schemes                      []string                `json:"schemes,omitempty"`
+	AllowDomain                  []string                `json:"allow_domain"`
+	AllowOriginPatterns          []string                `json:"allow_origin_patterns,omitempty"`
+	AllowOriginFunc              string                  `json:"allow_origin_func,omitempty"`
+	TXTAllowlist                 string                  `json:"txt_allowlist,omitempty"`
+	AllowSubdomain               bool                    `json:"allow_subdomain"`
+	SchemeDomains                map[string][]string     `json:"scheme_domains,omitempty"`
+	Methods                      []string                `json:"methods"`
+	AllowHeaders                 []string                `json:"allow_headers,omitempty"`
+	MaxAge                       string                  `json:"max_age"`
+	OptionsPassthrough           bool                    `json:"options_passthrough"`
+	PreflightStatus              int                     `json:"preflight_status"`
+	AllowCredentials             bool                    `json:"allow_credentials"`
+	PrivateCache                 bool                    `json:"private_cache"`
+	RequireSecureOrigin          bool                    `json:"require_secure_origin"`
+	AllowInsecureLocalhost       bool                    `json:"allow_insecure_localhost"`
+	OriginAgentCluster           bool                    `json:"origin_agent_cluster"`
+	PermittedCrossDomainPolicies string                  `json:"permitted_cross_domain_policies,omitempty"`
+	ContentSecurityPolicy        bool                    `json:"content_security_policy"`
+	CheckReferer                 bool                    `json:"check_referer"`
+	StrictDefaults               bool                    `json:"strict_defaults"`
+	SilentReject                 bool                    `json:"silent_reject"`
+	AllowFetchDest               []string                `json:"allow_fetch_dest,omitempty"`
+	VaryHeaders                  []string                `json:"vary_headers,omitempty"`
+	DecorateStatus               []int                   `json:"decorate_status,omitempty"`
+	SkipStatus                   []int                   `json:"skip_status,omitempty"`
+	ExemptPaths                  []string                `json:"exempt_paths,omitempty"`
+	CDNSafe                      bool                    `json:"cdn_safe"`
+	ProblemDetails               bool                    `json:"problem_details"`
+	DebugErrorPage               bool                    `json:"debug_error_page"`
+	RejectStatus                 int                     `json:"reject_status"`
+	ErrorHandler                 string                  `json:"error_handler,omitempty"`
+	DenialLog                    string                  `json:"denial_log,omitempty"`
+	ExposeHeaders                []string                `json:"expose_headers,omitempty"`
+	ExposeTrailers               []string                `json:"expose_trailers,omitempty"`
+	TimingAllowOrigin            []string                `json:"timing_allow_origin,omitempty"`
+	AllowSameHost                bool                    `json:"allow_same_host"`
+	AlwaysAllowOrigin            bool                    `json:"always_allow_origin"`
+	AllowClientIP                string                  `json:"allow_client_ip,omitempty"`
+	AllowClientCertificate       string                  `json:"allow_client_certificate,omitempty"`
+	AuditLog                     string                  `json:"audit_log,omitempty"`
+	Expires                      map[string]time.Time    `json:"expires,omitempty"`
+	Windows                      map[string][]windowJSON `json:"windows,omitempty"`
+	SunsetNotice                 string                  `json:"sunset_notice,omitempty"`
+	OnSunset                     string                  `json:"on_sunset,omitempty"`
+	Quota                        int                     `json:"quota,omitempty"`
+	QuotaWindow                  string                  `json:"quota_window,omitempty"`
+	QuotaStore                   string                  `json:"quota_store,omitempty"`
+	OriginHeader                 string                  `json:"origin_header,omitempty"`
+	TrustedProxies               []string                `json:"trusted_proxies,omitempty"`
+	NormalizeOrigin              string                  `json:"normalize_origin,omitempty"`
+	Messages                     map[string]string       `json:"messages,omitempty"`
+	RedactOrigin                 bool                    `json:"redact_origin"`
+	Diagnose                     bool                    `json:"diagnose"`
+	ProfilerLabels               bool                    `json:"profiler_labels"`
+	Recorder                     string                  `json:"recorder,omitempty"`
+	Canary                       map[string]int          `json:"canary,omitempty"`
+	Rand                         string                  `json:"rand,omitempty"`
+	Clock                        string                  `json:"clock,omitempty"`
+	Flags                        string                  `json:"flags,omitempty"`
+	Flag                         string                  `json:"flag,omitempty"`
+	FlagDomains                  map[string]string       `json:"flag_domains,omitempty"`
+	Listeners                    map[string]*Options     `json:"listeners,omitempty"`
+	Listener                     string                  `json:"listener,omitempty"`
+	Shadow                       *Options                `json:"shadow,omitempty"`
+}
+
+// MarshalJSON returns the effective options after applying default values as
+// JSON, with object keys in a stable order. Dynamic providers are redacted.
+func (opt Options) MarshalJSON() ([]byte, error) {
+	opt = prepareOptions([]Options{opt})
+
+	v := optionsJSON{
+		Scheme:                       opt.Scheme,
+		Schemes:                      opt.Schemes,
+		AllowDomain:                  opt.AllowDomain,
+		AllowOriginPatterns:          opt.AllowOriginPatterns,
+		AllowSubdomain:               opt.AllowSubdomain,
+		SchemeDomains:                opt.SchemeDomains,
+		Methods:                      opt.Methods,
+		AllowHeaders:                 allowHeaders(opt),
+		MaxAge:                       opt.MaxAge.String(),
+		OptionsPassthrough:           opt.OptionsPassthrough,
+		PreflightStatus:              opt.PreflightStatus,
+		AllowCredentials:             opt.AllowCredentials,
+		PrivateCache:                 opt.PrivateCache,
+		RequireSecureOrigin:          opt.RequireSecureOrigin,
+		AllowInsecureLocalhost:       opt.AllowInsecureLocalhost,
+		OriginAgentCluster:           opt.OriginAgentCluster,
+		PermittedCrossDomainPolicies: opt.PermittedCrossDomainPolicies,
+		ContentSecurityPolicy:        opt.ContentSecurityPolicy,
+		CheckReferer:                 opt.CheckReferer,
+		StrictDefaults:               opt.StrictDefaults,
+		SilentReject:                 opt.SilentReject,
+		AllowFetchDest:               opt.AllowFetchDest,
+		VaryHeaders:                  opt.VaryHeaders,
+		DecorateStatus:               opt.DecorateStatus,
+		SkipStatus:                   opt.SkipStatus,
+		ExemptPaths:                  opt.ExemptPaths,
+		CDNSafe:                      opt.CDNSafe,
+		ProblemDetails:               opt.ProblemDetails,
+		DebugErrorPage:               opt.DebugErrorPage,
+		RejectStatus:                 opt.RejectStatus,
+		Diagnose:                     opt.Diagnose,
+		ProfilerLabels:               opt.ProfilerLabels,
+		ExposeHeaders:                opt.ExposeHeaders,
+		ExposeTrailers:               opt.ExposeTrailers,
+		TimingAllowOrigin:            opt.TimingAllowOrigin,
+		AllowSameHost:                opt.AllowSameHost,
+		AlwaysAllowOrigin:            opt.AlwaysAllowOrigin,
+		Expires:                      opt.Expires,
+		Windows:                      windowsJSON(opt.Windows),
+		Quota:                        opt.Quota,
+		OriginHeader:                 opt.OriginHeader,
+		TrustedProxies:               opt.TrustedProxies,
+		Messages:                     opt.Messages,
+		RedactOrigin:                 opt.RedactOrigin,
+		Canary:                       opt.Canary,
+		Flag:                         opt.Flag,
+		FlagDomains:                  opt.FlagDomains,
+	}
+	if v.AllowDomain == nil {
+		v.AllowDomain = []string{}
+	}
+	if opt.AllowOriginFunc != nil {
+		v.AllowOriginFunc = redacted
+	}
+	if opt.TXTAllowlist != nil {
+		v.TXTAllowlist = opt.TXTAllowlist.Name
+	}
+	if opt.ErrorHandler != nil {
+		v.ErrorHandler = redacted
+	}
+	if opt.DenialLog != nil {
+		v.DenialLog = redacted
+	}
+	if opt.AllowClientIP != nil {
+		v.AllowClientIP = redacted
+	}
+	if opt.AllowClientCertificate != nil {
+		v.AllowClientCertificate = redacted
+	}
+	if opt.AuditLog != nil {
+		v.AuditLog = redacted
+	}
+	if opt.SunsetNotice > 0 {
+		v.SunsetNotice = opt.SunsetNotice.String()
+	}
+	if opt.OnSunset != nil {
+		v.OnSunset = redacted
+	}
+	if opt.Quota > 0 {
+		v.QuotaWindow = opt.QuotaWindow.String()
+	}
+	if opt.QuotaStore != nil {
+		v.QuotaStore = redacted
+	}
+	if opt.NormalizeOrigin != nil {
+		v.NormalizeOrigin = redacted
+	}
+	if opt.Recorder != nil {
+		v.Recorder = redacted
+	}
+	if opt.Rand != nil {
+		v.Rand = redacted
+	}
+	if opt.Clock != nil {
+		v.Clock = redacted
+	}
+	if opt.Flags != nil {
+		v.Flags = redacted
+	}
+	if opt.Shadow != nil {
+		v.Shadow = &opt.Shadow.Options
+	}
+	v.Listeners = opt.Listeners
+	if opt.Listener != nil {
+		v.Listener = redacted
+	}
+	return json.Marshal(v)
+}
+
+// String returns the effective options after applying default values in the
+// same format as MarshalJSON, so the policy can be logged and diffed.
+func (opt Options) String() string {
+	p, err := opt.MarshalJSON()
+	if err != nil {
+		return "cors.Options(" + err.Error() + ")"
+	}
+	return string(p)
+}
+
+// windowJSON is the JSON representation of a policy.Window, unbounded ends are
+// omitted.
+type windowJSON struct {
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// windowsJSON returns the JSON representation of the windows.
+func windowsJSON(windows map[string][]policy.Window) map[string][]windowJSON {
+	if windows == nil {
+		return nil
+	}
+	v := make(map[string][]windowJSON, len(windows))
+	for domain, ws := range windows {
+		for _, w := range ws {
+			var wj windowJSON
+			if !w.Start.IsZero() {
+				start := w.Start
+				wj.Start = &start
+			}
+			if !w.End.IsZero() {
+				end := w.End
+				wj.End = &end
+			}
+			v[domain] = append(v[domain], wj)
+		}
+	}
+	return v
+}
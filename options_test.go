@@ -0,0 +1,67 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/cors/policy"
+)
+
+func TestOptions_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Options
+		want string
+	}{
+		{
+			name: "defaults",
+			opt:  Options{},
+			want: `{"scheme":"http","allow_domain":["*"],"allow_subdomain":false,"methods":["GET","OPTIONS","POST"],"max_age":"10m0s","options_passthrough":false,"preflight_status":204,"allow_credentials":false,"private_cache":false,"require_secure_origin":false,"allow_insecure_localhost":false,"origin_agent_cluster":false,"content_security_policy":false,"check_referer":false,"strict_defaults":false,"silent_reject":false,"cdn_safe":false,"problem_details":false,"debug_error_page":false,"reject_status":400,"allow_same_host":false,"always_allow_origin":false,"redact_origin":false,"diagnose":false,"profiler_labels":false}`,
+		},
+		{
+			name: "strict defaults",
+			opt:  Options{StrictDefaults: true},
+			want: `{"scheme":"http","allow_domain":[],"allow_subdomain":false,"methods":["GET","HEAD","POST"],"max_age":"10m0s","options_passthrough":false,"preflight_status":204,"allow_credentials":false,"private_cache":false,"require_secure_origin":false,"allow_insecure_localhost":false,"origin_agent_cluster":false,"content_security_policy":false,"check_referer":false,"strict_defaults":true,"silent_reject":true,"cdn_safe":false,"problem_details":false,"debug_error_page":false,"reject_status":400,"allow_same_host":false,"always_allow_origin":false,"redact_origin":false,"diagnose":false,"profiler_labels":false}`,
+		},
+		{
+			name: "redacted providers",
+			opt: Options{
+				Scheme:      "https",
+				AllowDomain: []string{"example.com"},
+				MaxAge:      time.Minute,
+				Canary:      map[string]int{"b.com": 10, "a.com": 20},
+				DenialLog:   NewDenialLog(1),
+				Flags:       NewMemoryFlags(),
+				Shadow: &Shadow{
+					Options: Options{AllowDomain: []string{"example.org"}},
+				},
+			},
+			want: `{"scheme":"https","allow_domain":["example.com"],"allow_subdomain":false,"methods":["GET","OPTIONS","POST"],"max_age":"1m0s","options_passthrough":false,"preflight_status":204,"allow_credentials":false,"private_cache":false,"require_secure_origin":false,"allow_insecure_localhost":false,"origin_agent_cluster":false,"content_security_policy":false,"check_referer":false,"strict_defaults":false,"silent_reject":false,"cdn_safe":false,"problem_details":false,"debug_error_page":false,"reject_status":400,"denial_log":"[redacted]","allow_same_host":false,"always_allow_origin":false,"redact_origin":false,"diagnose":false,"profiler_labels":false,"canary":{"a.com":20,"b.com":10},"flags":"[redacted]","shadow":{"scheme":"http","allow_domain":["example.org"],"allow_subdomain":false,"methods":["GET","OPTIONS","POST"],"max_age":"10m0s","options_passthrough":false,"preflight_status":204,"allow_credentials":false,"private_cache":false,"require_secure_origin":false,"allow_insecure_localhost":false,"origin_agent_cluster":false,"content_security_policy":false,"check_referer":false,"strict_defaults":false,"silent_reject":false,"cdn_safe":false,"problem_details":false,"debug_error_page":false,"reject_status":400,"allow_same_host":false,"always_allow_origin":false,"redact_origin":false,"diagnose":false,"profiler_labels":false}}`,
+		},
+		{
+			name: "windows",
+			opt: Options{
+				AllowDomain: []string{"partner.com"},
+				Windows: map[string][]policy.Window{
+					"partner.com": {{Start: time.Date(2026, time.March, 7, 0, 0, 0, 0, time.FixedZone("", 8*60*60))}},
+				},
+			},
+			want: `{"scheme":"http","allow_domain":["partner.com"],"allow_subdomain":false,"methods":["GET","OPTIONS","POST"],"max_age":"10m0s","options_passthrough":false,"preflight_status":204,"allow_credentials":false,"private_cache":false,"require_secure_origin":false,"allow_insecure_localhost":false,"origin_agent_cluster":false,"content_security_policy":false,"check_referer":false,"strict_defaults":false,"silent_reject":false,"cdn_safe":false,"problem_details":false,"debug_error_page":false,"reject_status":400,"allow_same_host":false,"always_allow_origin":false,"windows":{"partner.com":[{"start":"2026-03-07T00:00:00+08:00"}]},"redact_origin":false,"diagnose":false,"profiler_labels":false}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := json.Marshal(test.opt)
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, string(got))
+			assert.Equal(t, test.want, test.opt.String())
+		})
+	}
+}
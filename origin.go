@@ -0,0 +1,105 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses IP addresses and CIDR ranges, it panics on an
+// invalid entry.
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				panic("cors: invalid trusted proxy " + p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			panic("cors: invalid trusted proxy " + p + ": " + err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// origin returns the origin of the request, from OriginHeader when the direct
+// peer is a trusted proxy and the header is present.
+func (h *handler) origin(r *http.Request) string {
+	if h.opt.OriginHeader == "" {
+		return r.Header.Get(HeaderOrigin)
+	}
+	if v := r.Header.Get(h.opt.OriginHeader); v != "" && h.fromTrustedProxy(r) {
+		return v
+	}
+	return r.Header.Get(HeaderOrigin)
+}
+
+// clientIP returns the IP address of the client of the request, which is the
+// direct peer unless it is a trusted proxy that forwards the address in the
+// "X-Real-IP" or "X-Forwarded-For" header.
+func (h *handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !h.fromTrustedProxy(r) {
+		return host
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	// Addresses are appended by every proxy, so the rightmost one that is not a
+	// trusted proxy is the first that the client could not have forged.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		host = ip
+		if !h.trustedIP(net.ParseIP(ip)) {
+			break
+		}
+	}
+	return host
+}
+
+// fromTrustedProxy returns true if the direct peer of the request is one of
+// the trusted proxies. Forwarded client addresses are not consulted since
+// they can be set by anyone.
+func (h *handler) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return h.trustedIP(net.ParseIP(host))
+}
+
+// trustedIP returns true if the IP address is one of the trusted proxies.
+func (h *handler) trustedIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// domainPattern is a compiled allowlist entry.
+type domainPattern struct {
+	// entry is the allowlist entry as configured.
+	entry string
+	// expires is when the entry stops matching, zero for never.
+	expires time.Time
+	// windows are the periods during which the entry matches, empty for always.
+	windows []Window
+	// any is true for the "!*" wildcard that matches any host.
+	any bool
+	// host is the host to match, including the port when the pattern has no
+	// port range.
+	host string
+	// ports is true when the pattern has a port wildcard or range, which is
+	// matched against the port of the host separately.
+	ports            bool
+	minPort, maxPort int
+	// labels are the labels of the host when it has "*" labels, nil otherwise.
+	labels []string
+}
+
+// compileDomain compiles the allowlist entry, which may end with a port
+// wildcard (e.g. "localhost:*") or an inclusive port range (e.g.
+// "127.0.0.1:3000-3999"), and may have "*" labels (e.g. "*.example.com" or
+// "api.*.example.com"). Any other entry is matched literally.
+func compileDomain(d string) domainPattern {
+	p := compilePattern(d)
+	p.entry = d
+	p.labels = wildcardLabels(p.host)
+	return p
+}
+
+// wildcardLabels returns the labels of the host if it has "*" labels and
+// every other label is free of "*", nil otherwise.
+func wildcardLabels(host string) []string {
+	if host == "*" || !strings.Contains(host, "*") {
+		return nil
+	}
+	labels := strings.Split(host, ".")
+	for _, l := range labels {
+		if l != "*" && strings.Contains(l, "*") {
+			return nil
+		}
+	}
+	return labels
+}
+
+func compilePattern(d string) domainPattern {
+	if d == "!*" {
+		return domainPattern{any: true}
+	}
+
+	host, port, err := net.SplitHostPort(d)
+	if err != nil {
+		return domainPattern{host: d}
+	}
+	if port == "*" {
+		return domainPattern{host: host, ports: true, minPort: 0, maxPort: 65535}
+	}
+	lo, hi, ok := strings.Cut(port, "-")
+	if !ok {
+		return domainPattern{host: d}
+	}
+	minPort, err := strconv.Atoi(lo)
+	if err != nil {
+		return domainPattern{host: d}
+	}
+	maxPort, err := strconv.Atoi(hi)
+	if err != nil || minPort > maxPort {
+		return domainPattern{host: d}
+	}
+	return domainPattern{host: host, ports: true, minPort: minPort, maxPort: maxPort}
+}
+
+// compileDomains compiles the list of allowlist entries with their expiry
+// timestamps and active windows, if any.
+func compileDomains(domains []string, expires map[string]time.Time, windows map[string][]Window) []domainPattern {
+	patterns := make([]domainPattern, 0, len(domains))
+	for _, d := range domains {
+		p := compileDomain(d)
+		p.expires = expires[d]
+		p.windows = windows[d]
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// match returns true if the host, which may include a port, is matched by the
+// pattern.
+func (p domainPattern) match(host string, allowSubdomain bool) bool {
+	if p.any {
+		return true
+	}
+
+	if p.ports {
+		h, port, err := net.SplitHostPort(host)
+		if err != nil {
+			return false
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < p.minPort || n > p.maxPort {
+			return false
+		}
+		host = h
+	}
+	if p.labels != nil {
+		return matchLabels(strings.Split(host, "."), p.labels, allowSubdomain)
+	}
+	return host == p.host ||
+		(allowSubdomain && strings.HasSuffix(host, "."+p.host))
+}
+
+// matchLabels returns true if the labels of a host are matched by the labels
+// of a pattern. A leading "*" matches one or more labels and any other "*"
+// matches exactly one label.
+func matchLabels(labels, pattern []string, allowSubdomain bool) bool {
+	minExtra := 0
+	if pattern[0] == "*" {
+		minExtra = 1
+		pattern = pattern[1:]
+	}
+	extra := len(labels) - len(pattern)
+	if extra < minExtra || (minExtra == 0 && extra > 0 && !allowSubdomain) {
+		return false
+	}
+	for _, l := range labels[:extra] {
+		if l == "" {
+			return false
+		}
+	}
+	for i, l := range labels[extra:] {
+		if pattern[i] == "*" {
+			if l == "" {
+				return false
+			}
+		} else if l != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withDefaultPort returns the host with the default port of the scheme when it
+// has no explicit port, e.g. "localhost:80" for "http" and "localhost".
+func withDefaultPort(host, scheme string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "ws":
+		return net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	case "https", "wss":
+		return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+	}
+	return host
+}
+
+// matchDomain returns the first of the patterns that matches the host of the
+// origin with the scheme and is active at the given time. Otherwise, it
+// returns the expired or inactive pattern that would have matched, if any.
+func matchDomain(host, scheme string, patterns []domainPattern, allowSubdomain bool, now time.Time) (matched, inactive *domainPattern) {
+	for i, p := range patterns {
+		h := host
+		if p.ports {
+			// Port patterns include the default port of the scheme
+			h = withDefaultPort(host, scheme)
+		}
+		if !p.match(h, allowSubdomain) {
+			continue
+		}
+		if (!p.expires.IsZero() && !now.Before(p.expires)) || !activeAt(p.windows, now) {
+			inactive = &patterns[i]
+			continue
+		}
+		return &patterns[i], nil
+	}
+	return nil, inactive
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainPattern_Match(t *testing.T) {
+	tests := []struct {
+		name           string
+		domain         string
+		host           string
+		allowSubdomain bool
+		want           bool
+	}{
+		{name: "exact", domain: "example.com", host: "example.com", want: true},
+		{name: "exact with port", domain: "example.com:8080", host: "example.com:8080", want: true},
+		{name: "mismatched port", domain: "example.com:8080", host: "example.com:8081", want: false},
+		{name: "subdomain", domain: "example.com", host: "a.example.com", allowSubdomain: true, want: true},
+		{name: "reflect any", domain: "!*", host: "evil.com", want: true},
+
+		{name: "port wildcard", domain: "localhost:*", host: "localhost:3000", want: true},
+		{name: "port wildcard without port", domain: "localhost:*", host: "localhost", want: false},
+		{name: "port wildcard other host", domain: "localhost:*", host: "evil.com:3000", want: false},
+		{name: "port wildcard subdomain", domain: "example.com:*", host: "a.example.com:3000", allowSubdomain: true, want: true},
+		{name: "port wildcard IPv6", domain: "[::1]:*", host: "[::1]:3000", want: true},
+
+		{name: "port range lower bound", domain: "127.0.0.1:3000-3999", host: "127.0.0.1:3000", want: true},
+		{name: "port range upper bound", domain: "127.0.0.1:3000-3999", host: "127.0.0.1:3999", want: true},
+		{name: "port range below", domain: "127.0.0.1:3000-3999", host: "127.0.0.1:2999", want: false},
+		{name: "port range above", domain: "127.0.0.1:3000-3999", host: "127.0.0.1:4000", want: false},
+		{name: "port range without port", domain: "127.0.0.1:3000-3999", host: "127.0.0.1", want: false},
+
+		{name: "leading wildcard", domain: "*.example.com", host: "a.example.com", want: true},
+		{name: "leading wildcard nested", domain: "*.example.com", host: "a.b.example.com", want: true},
+		{name: "leading wildcard apex", domain: "*.example.com", host: "example.com", want: false},
+		{name: "leading wildcard other domain", domain: "*.example.com", host: "a.evil.com", want: false},
+		{name: "leading wildcard suffix", domain: "*.example.com", host: "a.example.com.evil.com", want: false},
+		{name: "leading wildcard empty label", domain: "*.example.com", host: ".example.com", want: false},
+		{name: "leading wildcard with port", domain: "*.example.com:8080", host: "a.example.com:8080", want: true},
+		{name: "leading wildcard mismatched port", domain: "*.example.com:8080", host: "a.example.com:8081", want: false},
+		{name: "leading wildcard port wildcard", domain: "*.example.com:*", host: "a.example.com:3000", want: true},
+		{name: "inner wildcard", domain: "api.*.example.com", host: "api.eu.example.com", want: true},
+		{name: "inner wildcard one label", domain: "api.*.example.com", host: "api.a.eu.example.com", want: false},
+		{name: "inner wildcard subdomain", domain: "api.*.example.com", host: "v1.api.eu.example.com", want: false},
+		{name: "inner wildcard with AllowSubdomain", domain: "api.*.example.com", host: "v1.api.eu.example.com", allowSubdomain: true, want: true},
+		{name: "partial wildcard is literal", domain: "api-*.example.com", host: "api-eu.example.com", want: false},
+
+		{name: "invalid range is literal", domain: "localhost:3999-3000", host: "localhost:3999-3000", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, compileDomain(test.domain).match(test.host, test.allowSubdomain))
+		})
+	}
+}
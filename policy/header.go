@@ -0,0 +1,39 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"strings"
+)
+
+// HeaderNames parses the values of a header that contains a comma separated
+// list of header names, e.g. "Access-Control-Request-Headers", which may be
+// split over multiple header lines. Whitespace around the commas and empty
+// items are ignored, and duplicate names are only kept on first occurrence. It
+// is the single normalization used for both validating and echoing requested
+// headers.
+func HeaderNames(values ...string) []string {
+	var names []string
+	for _, v := range values {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.Trim(f, " \t")
+			if f != "" && !HasHeader(names, f) {
+				names = append(names, f)
+			}
+		}
+	}
+	return names
+}
+
+// HasHeader returns true if the list contains the header name. Header names
+// are compared byte-case-insensitively as required by the Fetch spec.
+func HasHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
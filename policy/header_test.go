@@ -0,0 +1,68 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{
+			name:   "empty",
+			values: nil,
+			want:   nil,
+		},
+		{
+			name:   "single",
+			values: []string{"Content-Type"},
+			want:   []string{"Content-Type"},
+		},
+		{
+			name:   "no whitespace",
+			values: []string{"content-type,x-token"},
+			want:   []string{"content-type", "x-token"},
+		},
+		{
+			name:   "optional whitespace",
+			values: []string{"content-type, x-token ,\tx-request-id"},
+			want:   []string{"content-type", "x-token", "x-request-id"},
+		},
+		{
+			name:   "empty items",
+			values: []string{",content-type,, ,x-token,"},
+			want:   []string{"content-type", "x-token"},
+		},
+		{
+			name:   "duplicates",
+			values: []string{"content-type, X-Token, Content-Type, x-token"},
+			want:   []string{"content-type", "X-Token"},
+		},
+		{
+			name:   "multiple lines",
+			values: []string{"content-type", "", " x-token ,content-type"},
+			want:   []string{"content-type", "x-token"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, HeaderNames(test.values...))
+		})
+	}
+}
+
+func TestHasHeader(t *testing.T) {
+	names := []string{"Content-Type", "x-token"}
+	assert.True(t, HasHeader(names, "content-type"))
+	assert.True(t, HasHeader(names, "X-TOKEN"))
+	assert.False(t, HasHeader(names, "Authorization"))
+	assert.False(t, HasHeader(nil, "Content-Type"))
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build js && wasm
+
+package policy
+
+import (
+	"syscall/js"
+)
+
+// Expose registers a JavaScript function with the given name on the global
+// object, which evaluates requests against the policy so that browser-based
+// tooling runs the exact policy of the server, e.g. as returned by
+// cors.Options.Policy. The function accepts an object with the optional
+// "origin", "referer", "fetchDest", "method", "requestMethod",
+// "requestHeaders" (the value of the "Access-Control-Request-Headers" header),
+// "host", "serverOrigin" and "clientIP" properties of the request, and returns
+// an object with the "allowOrigin", "rule", "preflight" and "denial"
+// properties, where "denial" is null for allowed requests and has the "code",
+// "message", "detail" and "hint" properties otherwise. The returned function
+// unregisters and releases the JavaScript function.
+func (p *Policy) Expose(name string) (release func()) {
+	fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		var req Request
+		if len(args) > 0 && args[0].Type() == js.TypeObject {
+			v := args[0]
+			str := func(key string) string {
+				if prop := v.Get(key); prop.Type() == js.TypeString {
+					return prop.String()
+				}
+				return ""
+			}
+			req = Request{
+				Origin:        str("origin"),
+				Referer:       str("referer"),
+				FetchDest:     str("fetchDest"),
+				Method:        str("method"),
+				RequestMethod: str("requestMethod"),
+				Host:          str("host"),
+				ServerOrigin:  str("serverOrigin"),
+				ClientIP:      str("clientIP"),
+			}
+			if req.Preflight() {
+				req.Headers = HeaderNames(str("requestHeaders"))
+			}
+		}
+
+		result := p.Evaluate(req)
+		var denial interface{}
+		if result.Denial != nil {
+			denial = map[string]interface{}{
+				"code":    result.Denial.Code,
+				"message": result.Denial.Message,
+				"detail":  result.Denial.Detail,
+				"hint":    result.Denial.Hint,
+			}
+		}
+		return map[string]interface{}{
+			"allowOrigin": result.AllowOrigin,
+			"rule":        result.Rule,
+			"preflight":   req.Preflight(),
+			"denial":      denial,
+		}
+	})
+	js.Global().Set(name, fn)
+	return func() {
+		js.Global().Delete(name)
+		fn.Release()
+	}
+}
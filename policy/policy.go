@@ -0,0 +1,617 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package policy implements the CORS policy engine without depending on any
+// web framework, so the same policy can be evaluated by the Flamego middleware,
+// proxies, WebSocket servers and tests. It also builds for GOOS=js GOARCH=wasm
+// to evaluate the policy of the server in browser-based tooling, see
+// Policy.Expose.
+package policy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config contains the configuration of a CORS policy. Unlike the options of
+// the Flamego middleware, no default values are applied.
+type Config struct {
+	// Scheme may be http or https to replace the scheme of allowed origins in the
+	// "Access-Control-Allow-Origin" header, or empty or the "*" wildcard to keep
+	// the scheme of the requesting origin.
+	Scheme string
+	// Schemes is the list of schemes of origins that are allowed, e.g.
+	// ["https", "capacitor"] for hybrid apps. When set, Scheme is ignored,
+	// origins with other schemes are denied and allowed origins keep their own
+	// scheme, while the "*" wildcard still replies with "*". It is ignored when
+	// SchemeDomains is set.
+	Schemes []string
+	// AllowDomain is the list of domains that are allowed to initiate CORS
+	// requests. Special value is a single "*" wildcard that allows any domain
+	// without reflection and the special "!*" wildcard that reflects any
+	// requesting domain.
+	AllowDomain []string
+	// AllowSubdomain allowed subdomains of domains to run CORS requests.
+	AllowSubdomain bool
+	// SchemeDomains maps a scheme to the list of domains that are allowed to
+	// initiate CORS requests over that scheme. When set, Scheme and AllowDomain
+	// are ignored.
+	SchemeDomains map[string][]string
+	// RequireSecureOrigin set to true denies any non-HTTPS origin.
+	RequireSecureOrigin bool
+	// AllowInsecureLocalhost set to true exempts loopback origins from
+	// RequireSecureOrigin.
+	AllowInsecureLocalhost bool
+	// CheckReferer set to true denies any request whose "Referer" does not
+	// agree with its "Origin" on the scheme and host, when both are present.
+	CheckReferer bool
+	// Canary maps a domain that is being rolled out to the percentage (0 to 100)
+	// of its requests that are allowed, others are denied as if the domain was
+	// not in the allowlist. Subdomains are matched as with AllowSubdomain.
+	Canary map[string]int
+	// Gated maps a domain to a function that reports whether the domain is
+	// currently allowed, it is consulted on every request so the domain can be
+	// toggled at runtime, with the context of the request. Subdomains are
+	// matched as with AllowSubdomain.
+	Gated map[string]func(ctx context.Context) bool
+	// AllowOriginPatterns is the list of regular expressions that must match
+	// the full origin, e.g. `https://pr-\d+\.preview\.example\.com`, for
+	// origins that AllowDomain cannot express. They are anchored at both ends
+	// implicitly, and allowed origins are reflected as sent.
+	AllowOriginPatterns []*regexp.Regexp
+	// AllowOriginFunc reports whether the origin is allowed, with the context of
+	// the request, e.g. by looking it up in a database of registered customer
+	// domains. When set, it is consulted instead of AllowDomain, SchemeDomains,
+	// Canary and Gated, and allowed origins are reflected as sent.
+	AllowOriginFunc func(ctx context.Context, origin string) bool
+	// NormalizeOrigin is applied to the origin before it is matched, e.g. to
+	// strip vanity subdomains, and returning an error denies the request as an
+	// invalid origin. Allowed origins are still reflected as sent.
+	NormalizeOrigin func(ctx context.Context, origin string) (string, error)
+	// AllowSameHost set to true allows origins on the same host as the server,
+	// see Request.Host, with any port when they are not allowed otherwise.
+	AllowSameHost bool
+	// AlwaysAllowOrigin set to true allows the origin of the server, see
+	// Request.ServerOrigin, when it is not allowed otherwise, and answers
+	// requests without an origin with it.
+	AlwaysAllowOrigin bool
+	// AllowClientCertificate reports whether the origin is allowed by the
+	// verified client certificate of Request.TLS when it is not allowed
+	// otherwise, with the context of the request.
+	AllowClientCertificate func(ctx context.Context, origin string, state *tls.ConnectionState) bool
+	// AllowClientIP reports whether the allowed origin may be used from the
+	// client IP of Request.ClientIP, which is nil when it cannot be parsed, with
+	// the context of the request. Returning false denies the request.
+	AllowClientIP func(ctx context.Context, origin string, ip net.IP) bool
+	// Expires maps an entry of AllowDomain or SchemeDomains to the time after
+	// which it no longer allows any origin, e.g. for time-boxed partner
+	// integrations.
+	Expires map[string]time.Time
+	// Windows maps an entry of AllowDomain or SchemeDomains to the periods of
+	// time during which it allows origins, e.g. for a partner migration weekend,
+	// the entry allows no origin outside of them.
+	Windows map[string][]Window
+	// AllowFetchDest is the list of request destinations, as sent by browsers in
+	// the "Sec-Fetch-Dest" header, that are allowed, e.g. ["empty"] to allow
+	// fetch and XHR but deny being loaded as a document, iframe or script. It
+	// applies to every request that has the header, including non-CORS ones.
+	AllowFetchDest []string
+	// AllowHeaders is the list of request headers that preflight requests may
+	// ask for, others are denied. Default is nil (any header).
+	AllowHeaders []string
+	// Clock returns the current time for Expires and Windows. Default is
+	// time.Now.
+	Clock func() time.Time
+	// Rand returns a non-negative pseudo-random number less than n for Canary.
+	// It must be safe for concurrent use. Default is rand.Intn.
+	Rand func(n int) int
+}
+
+// Request contains the values of a request that are consulted by the policy.
+type Request struct {
+	// Origin is the value of the "Origin" request header.
+	Origin string
+	// Referer is the value of the "Referer" request header.
+	Referer string
+	// FetchDest is the value of the "Sec-Fetch-Dest" request header.
+	FetchDest string
+	// Headers is the list of headers that a preflight request asks for in the
+	// "Access-Control-Request-Headers" header, see HeaderNames.
+	Headers []string
+	// Method is the method of the request, e.g. "OPTIONS".
+	Method string
+	// RequestMethod is the value of the "Access-Control-Request-Method" request
+	// header.
+	RequestMethod string
+	// Host is the host of the server as addressed by the request, e.g.
+	// "localhost:8080", for AllowSameHost.
+	Host string
+	// ServerOrigin is the origin of the server as addressed by the request, e.g.
+	// "https://example.com", for AlwaysAllowOrigin.
+	ServerOrigin string
+	// ClientIP is the IP address of the client, for AllowClientIP.
+	ClientIP string
+	// TLS is the state of the TLS connection of the request, for
+	// AllowClientCertificate.
+	TLS *tls.ConnectionState
+}
+
+// Preflight returns true if the request is a CORS preflight request.
+func (r Request) Preflight() bool {
+	return r.Origin != "" && r.Method == "OPTIONS" && r.RequestMethod != ""
+}
+
+// Codes of the reasons of denials.
+const (
+	CodeMismatchedReferer = "mismatched_referer"
+	CodeInvalidOrigin     = "invalid_origin"
+	CodeInsecureOrigin    = "insecure_origin"
+	CodeProhibitedDomain  = "prohibited_domain"
+	CodeProhibitedClient  = "prohibited_client"
+	CodeProhibitedDest    = "prohibited_destination"
+	CodeProhibitedHeader  = "prohibited_header"
+)
+
+// Denial is the reason of a request being denied by the policy.
+type Denial struct {
+	// Code identifies the reason, e.g. CodeProhibitedDomain, for looking up
+	// translated messages.
+	Code string
+	// Value is the offending value that is included in the message, e.g. the
+	// origin.
+	Value string
+	// Message is the client-visible error message.
+	Message string
+	// Detail names the failing check.
+	Detail string
+	// Hint suggests how to fix the configuration.
+	Hint string
+}
+
+// Result is the outcome of evaluating a request against the policy.
+type Result struct {
+	// AllowOrigin is the value of the "Access-Control-Allow-Origin" response
+	// header, it is empty for non-CORS requests and denials.
+	AllowOrigin string
+	// Denial is the reason of the request being denied, it is nil when the
+	// request is allowed.
+	Denial *Denial
+	// Expires is the expiry of the allowlist entry that allowed the origin, it is
+	// zero when the entry never expires.
+	Expires time.Time
+	// Rule is the allowlist entry that allowed the origin as configured, e.g.
+	// "example.com", the "*" wildcard or a pattern of AllowOriginPatterns.
+	Rule string
+}
+
+// Policy is a CORS policy, it is safe for concurrent use.
+type Policy struct {
+	config Config
+
+	allowDomain    []domainPattern
+	schemeDomains  map[string][]domainPattern
+	canary         []canaryPattern
+	gated          []gatedPattern
+	originPatterns []originPattern
+}
+
+// canaryPattern is a compiled canary domain.
+type canaryPattern struct {
+	domainPattern
+	percent int
+}
+
+// gatedPattern is a compiled gated domain.
+type gatedPattern struct {
+	domainPattern
+	enabled func(ctx context.Context) bool
+}
+
+// originPattern is a compiled origin pattern, anchored at both ends.
+type originPattern struct {
+	entry string
+	re    *regexp.Regexp
+}
+
+// New returns a new Policy with the given configuration. Allowlist entries may
+// end with a port wildcard (e.g. "localhost:*") or an inclusive port range
+// (e.g. "127.0.0.1:3000-3999") to match any port or ports in the range, where
+// origins without a port have the default port of their scheme, and may have
+// "*" labels: a leading "*" matches one or more labels (e.g.
+// "*.example.com") and any other matches exactly one (e.g.
+// "api.*.example.com"). Patterns are compiled once.
+func New(config Config) *Policy {
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	if config.Rand == nil {
+		config.Rand = rand.Intn
+	}
+	p := &Policy{
+		config:      config,
+		allowDomain: compileDomains(config.AllowDomain, config.Expires, config.Windows),
+	}
+	if len(config.SchemeDomains) > 0 {
+		p.schemeDomains = make(map[string][]domainPattern, len(config.SchemeDomains))
+		for scheme, domains := range config.SchemeDomains {
+			p.schemeDomains[scheme] = compileDomains(domains, config.Expires, config.Windows)
+		}
+	}
+	for d, percent := range config.Canary {
+		p.canary = append(p.canary, canaryPattern{compileDomain(d), percent})
+	}
+	for d, enabled := range config.Gated {
+		p.gated = append(p.gated, gatedPattern{compileDomain(d), enabled})
+	}
+	for _, re := range config.AllowOriginPatterns {
+		p.originPatterns = append(p.originPatterns, originPattern{
+			entry: re.String(),
+			re:    regexp.MustCompile(`^(?:` + re.String() + `)$`),
+		})
+	}
+	return p
+}
+
+// AllowAnyDomain returns true if the policy allows any domain with the "*"
+// wildcard.
+func (p *Policy) AllowAnyDomain() bool {
+	c := p.config
+	return c.AllowOriginFunc == nil && len(c.SchemeDomains) == 0 && len(c.AllowDomain) > 0 && c.AllowDomain[0] == "*"
+}
+
+// Evaluate evaluates the request against the policy.
+func (p *Policy) Evaluate(req Request) Result {
+	return p.EvaluateContext(context.Background(), req)
+}
+
+// EvaluateContext evaluates the request against the policy, the context is
+// passed to the functions of the configuration, e.g. AllowOriginFunc.
+func (p *Policy) EvaluateContext(ctx context.Context, req Request) Result {
+	c := p.config
+	origin := req.Origin
+	if c.NormalizeOrigin != nil && origin != "" {
+		normalized, err := c.NormalizeOrigin(ctx, origin)
+		if err != nil {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeInvalidOrigin,
+					Value:   err.Error(),
+					Message: fmt.Sprintf("Unable to parse CORS origin header: %v", err),
+					Detail:  fmt.Sprintf("origin %s was rejected by NormalizeOrigin: %v", origin, err),
+					Hint:    "Send an origin that is accepted by the NormalizeOrigin hook, or change the hook.",
+				},
+			}
+		}
+		req.Origin = normalized
+	}
+
+	result := p.evaluateOrigin(ctx, req)
+	if req.Origin != origin && result.AllowOrigin != "" && result.AllowOrigin != "*" {
+		// Browsers only accept their own serialization of the origin
+		result.AllowOrigin = origin
+	}
+	req.Origin = origin
+	if result.Denial != nil && result.Denial.Code == CodeProhibitedDomain {
+		result = p.evaluateExceptions(ctx, req, result)
+	}
+	if result.Denial == nil && result.AllowOrigin == "" && c.AlwaysAllowOrigin {
+		result.AllowOrigin = req.ServerOrigin
+	}
+	if result.Denial == nil && origin != "" && c.AllowClientIP != nil &&
+		!c.AllowClientIP(ctx, origin, net.ParseIP(req.ClientIP)) {
+		return Result{
+			Denial: &Denial{
+				Code:    CodeProhibitedClient,
+				Value:   origin,
+				Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+				Detail:  fmt.Sprintf("client IP %s is not allowed for origin %s by AllowClientIP", req.ClientIP, origin),
+				Hint:    "Send the request from an allowed network, or change the AllowClientIP hook.",
+			},
+		}
+	}
+	if result.Denial != nil || len(req.Headers) == 0 {
+		return result
+	}
+
+	if c.AllowHeaders == nil || HasHeader(c.AllowHeaders, "*") {
+		return result
+	}
+	for _, name := range req.Headers {
+		if !HasHeader(c.AllowHeaders, name) {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeProhibitedHeader,
+					Value:   name,
+					Message: fmt.Sprintf("CORS request with prohibited header %v", Sanitize(name)),
+					Detail:  fmt.Sprintf("requested header %s is not in AllowHeaders %v", name, c.AllowHeaders),
+					Hint:    fmt.Sprintf("Add %q to AllowHeaders if the client is meant to send it.", name),
+				},
+			}
+		}
+	}
+	return result
+}
+
+// evaluateExceptions returns the result of an origin from a prohibited domain
+// that is allowed by AllowSameHost, AlwaysAllowOrigin or
+// AllowClientCertificate, or the given result otherwise.
+func (p *Policy) evaluateExceptions(ctx context.Context, req Request, result Result) Result {
+	c := p.config
+	switch {
+	case c.AllowSameHost && sameHostname(req.Origin, req.Host):
+		return Result{AllowOrigin: req.Origin, Rule: "AllowSameHost"}
+	case c.AlwaysAllowOrigin && req.ServerOrigin != "" && strings.EqualFold(req.Origin, req.ServerOrigin):
+		return Result{AllowOrigin: req.Origin, Rule: "AlwaysAllowOrigin"}
+	case c.AllowClientCertificate != nil && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 &&
+		c.AllowClientCertificate(ctx, req.Origin, req.TLS):
+		return Result{AllowOrigin: req.Origin, Rule: "AllowClientCertificate"}
+	}
+	return result
+}
+
+// evaluateOrigin evaluates the request against the policy without the
+// requested headers and the exceptions.
+func (p *Policy) evaluateOrigin(ctx context.Context, req Request) Result {
+	c := p.config
+	origin := req.Origin
+	if len(c.AllowFetchDest) > 0 && req.FetchDest != "" && !containsFold(c.AllowFetchDest, req.FetchDest) {
+		return Result{
+			Denial: &Denial{
+				Code:    CodeProhibitedDest,
+				Value:   req.FetchDest,
+				Message: fmt.Sprintf("Request with prohibited fetch destination %v", Sanitize(req.FetchDest)),
+				Detail:  fmt.Sprintf("fetch destination %s is not in AllowFetchDest %v", req.FetchDest, c.AllowFetchDest),
+				Hint:    fmt.Sprintf("Add %q to AllowFetchDest if the resource is meant to be loaded that way.", req.FetchDest),
+			},
+		}
+	}
+	if origin == "" && !p.AllowAnyDomain() {
+		// Skip non-CORS requests
+		return Result{}
+	}
+
+	if c.CheckReferer && origin != "" && req.Referer != "" && !sameOrigin(origin, req.Referer) {
+		return Result{
+			Denial: &Denial{
+				Code:    CodeMismatchedReferer,
+				Value:   req.Referer,
+				Message: fmt.Sprintf("CORS request with mismatched referer %v", Sanitize(req.Referer)),
+				Detail:  fmt.Sprintf("referer %s does not match origin %s; CheckReferer=true", req.Referer, origin),
+				Hint:    "Make sure the Referer and Origin headers name the same page origin, or unset CheckReferer.",
+			},
+		}
+	}
+
+	var u *url.URL
+	if origin != "" && (!p.AllowAnyDomain() || c.RequireSecureOrigin || len(c.Schemes) > 0) {
+		var err error
+		u, err = url.Parse(origin)
+		if err != nil {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeInvalidOrigin,
+					Value:   err.Error(),
+					Message: fmt.Sprintf("Unable to parse CORS origin header: %v", Sanitize(err.Error())),
+					Detail:  fmt.Sprintf("origin is not a valid URL: %v", err),
+					Hint:    `Send a serialized origin such as "https://example.com" in the Origin header.`,
+				},
+			}
+		}
+		if c.RequireSecureOrigin && !isSecureOrigin(u, c.AllowInsecureLocalhost) {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeInsecureOrigin,
+					Value:   origin,
+					Message: fmt.Sprintf("CORS request from insecure origin %v", Sanitize(origin)),
+					Detail:  fmt.Sprintf("origin scheme %s is not https; RequireSecureOrigin=true, AllowInsecureLocalhost=%v", u.Scheme, c.AllowInsecureLocalhost),
+					Hint:    "Serve the page over HTTPS, or set AllowInsecureLocalhost to allow loopback origins.",
+				},
+			}
+		}
+	}
+
+	if u != nil && len(c.SchemeDomains) == 0 && len(c.Schemes) > 0 && !containsFold(c.Schemes, u.Scheme) {
+		return Result{
+			Denial: &Denial{
+				Code:    CodeProhibitedDomain,
+				Value:   origin,
+				Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+				Detail:  fmt.Sprintf("origin scheme %s is not in Schemes %v", u.Scheme, c.Schemes),
+				Hint:    fmt.Sprintf("Add %q to Schemes if the client is meant to use it.", u.Scheme),
+			},
+		}
+	}
+
+	if p.AllowAnyDomain() {
+		return Result{AllowOrigin: "*", Rule: "*"}
+	}
+	if c.AllowOriginFunc != nil {
+		if c.AllowOriginFunc(ctx, origin) {
+			return Result{AllowOrigin: origin, Rule: "AllowOriginFunc"}
+		}
+		return Result{
+			Denial: &Denial{
+				Code:    CodeProhibitedDomain,
+				Value:   origin,
+				Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+				Detail:  fmt.Sprintf("origin %s was not allowed by AllowOriginFunc", origin),
+				Hint:    "Register the origin with the source that AllowOriginFunc consults, or change the hook.",
+			},
+		}
+	}
+
+	domains, patterns := c.AllowDomain, p.allowDomain
+	if len(c.SchemeDomains) > 0 {
+		domains, patterns = c.SchemeDomains[u.Scheme], p.schemeDomains[u.Scheme]
+	}
+	now := c.Clock()
+	matched, inactive := matchDomain(u.Host, u.Scheme, patterns, c.AllowSubdomain, now)
+	if matched == nil {
+		for _, op := range p.originPatterns {
+			if op.re.MatchString(origin) {
+				return Result{AllowOrigin: origin, Rule: op.entry}
+			}
+		}
+		if inactive != nil && !inactive.expires.IsZero() && !now.Before(inactive.expires) {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeProhibitedDomain,
+					Value:   origin,
+					Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+					Detail:  fmt.Sprintf("origin host %s matched allowlist entry %s that expired at %s", u.Host, inactive.entry, inactive.expires.Format(time.RFC3339)),
+					Hint:    fmt.Sprintf("Extend the expiry of %q in Expires, or remove the entry.", inactive.entry),
+				},
+			}
+		}
+		if inactive != nil {
+			return Result{
+				Denial: &Denial{
+					Code:    CodeProhibitedDomain,
+					Value:   origin,
+					Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+					Detail:  fmt.Sprintf("origin host %s matched allowlist entry %s outside of its active windows at %s", u.Host, inactive.entry, now.Format(time.RFC3339)),
+					Hint:    fmt.Sprintf("Add a window covering the current time to Windows for %q, or remove the entry.", inactive.entry),
+				},
+			}
+		}
+
+		if g, ok := p.matchGated(u.Host); ok {
+			if g.enabled(ctx) {
+				return Result{AllowOrigin: p.allowOrigin(u), Rule: g.entry}
+			}
+			return Result{
+				Denial: &Denial{
+					Code:    CodeProhibitedDomain,
+					Value:   origin,
+					Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+					Detail:  fmt.Sprintf("origin host %s is gated and currently disabled", u.Host),
+					Hint:    fmt.Sprintf("Enable the gate of %q, or add it to the allowlist.", u.Host),
+				},
+			}
+		}
+		if cp, ok := p.matchCanary(u.Host); ok {
+			if c.Rand(100) < cp.percent {
+				return Result{AllowOrigin: p.allowOrigin(u), Rule: cp.entry}
+			}
+			return Result{
+				Denial: &Denial{
+					Code:    CodeProhibitedDomain,
+					Value:   origin,
+					Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+					Detail:  fmt.Sprintf("origin host %s is a canary allowed for %d%% of requests and was not selected", u.Host, cp.percent),
+					Hint:    fmt.Sprintf("Raise the percentage of %q in Canary, or promote it to the allowlist once the rollout is complete.", u.Host),
+				},
+			}
+		}
+
+		d := &Denial{
+			Code:    CodeProhibitedDomain,
+			Value:   origin,
+			Message: fmt.Sprintf("CORS request from prohibited domain %v", Sanitize(origin)),
+			Detail:  fmt.Sprintf("origin host %s did not match allowlist entries %v; AllowSubdomain=%v", u.Host, domains, c.AllowSubdomain),
+			Hint:    fmt.Sprintf("Add %q to AllowDomain, or set AllowSubdomain if it is a subdomain of an allowed domain.", u.Host),
+		}
+		if len(c.SchemeDomains) > 0 {
+			d.Detail = fmt.Sprintf("origin host %s did not match allowlist entries %v for scheme %s; AllowSubdomain=%v", u.Host, domains, u.Scheme, c.AllowSubdomain)
+			d.Hint = fmt.Sprintf("Add %q to SchemeDomains[%q].", u.Host, u.Scheme)
+		}
+		return Result{Denial: d}
+	}
+	return Result{
+		AllowOrigin: p.allowOrigin(u),
+		Expires:     matched.expires,
+		Rule:        matched.entry,
+	}
+}
+
+// allowOrigin returns the value of the "Access-Control-Allow-Origin" response
+// header for the allowed origin.
+func (p *Policy) allowOrigin(u *url.URL) string {
+	c := p.config
+	if len(c.SchemeDomains) == 0 && len(c.Schemes) == 0 && c.Scheme != "" && c.Scheme != "*" {
+		u.Scheme = c.Scheme
+	}
+	return u.String()
+}
+
+// matchCanary returns the canary pattern of the host and true if the host is a
+// canary.
+func (p *Policy) matchCanary(host string) (c canaryPattern, ok bool) {
+	for _, c := range p.canary {
+		if c.match(host, p.config.AllowSubdomain) {
+			return c, true
+		}
+	}
+	return canaryPattern{}, false
+}
+
+// matchGated returns the gated pattern of the host and true if the host is
+// gated.
+func (p *Policy) matchGated(host string) (g gatedPattern, ok bool) {
+	for _, g := range p.gated {
+		if g.match(host, p.config.AllowSubdomain) {
+			return g, true
+		}
+	}
+	return gatedPattern{}, false
+}
+
+// containsFold returns true if the list contains the value, ignoring case.
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalhost returns true if the host is a loopback name or address.
+func isLocalhost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameHostname returns true if the origin has the same hostname as the host,
+// regardless of the ports.
+func sameHostname(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" || host == "" {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.EqualFold(u.Hostname(), strings.Trim(host, "[]"))
+}
+
+// isSecureOrigin returns true if the origin is served over HTTPS, or is a
+// loopback origin when insecure localhost is allowed.
+func isSecureOrigin(u *url.URL, allowInsecureLocalhost bool) bool {
+	return u.Scheme == "https" ||
+		(allowInsecureLocalhost && isLocalhost(u.Hostname()))
+}
+
+// sameOrigin returns true if both URLs have the same scheme and host.
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,542 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Evaluate(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          Config
+		req             Request
+		wantAllowOrigin string
+		wantExpires     bool
+		wantDenial      string
+	}{
+		{
+			name:   "non-CORS request",
+			config: Config{AllowDomain: []string{"example.com"}},
+			req:    Request{},
+		},
+		{
+			name:            "wildcard",
+			config:          Config{AllowDomain: []string{"*"}},
+			req:             Request{Origin: "http://example.com"},
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "wildcard non-CORS request",
+			config:          Config{AllowDomain: []string{"*"}},
+			req:             Request{},
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "reflect any domain",
+			config:          Config{AllowDomain: []string{"!*"}},
+			req:             Request{Origin: "https://example.com"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name:            "exact domain",
+			config:          Config{AllowDomain: []string{"example.com"}},
+			req:             Request{Origin: "https://example.com"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name: "replace scheme",
+			config: Config{
+				Scheme:      "https",
+				AllowDomain: []string{"example.com"},
+			},
+			req:             Request{Origin: "http://example.com"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name: "subdomain",
+			config: Config{
+				AllowDomain:    []string{"example.com"},
+				AllowSubdomain: true,
+			},
+			req:             Request{Origin: "https://a.example.com"},
+			wantAllowOrigin: "https://a.example.com",
+		},
+		{
+			name:       "prohibited subdomain",
+			config:     Config{AllowDomain: []string{"example.com"}},
+			req:        Request{Origin: "https://a.example.com"},
+			wantDenial: "CORS request from prohibited domain https://a.example.com",
+		},
+		{
+			name:       "no domain",
+			config:     Config{},
+			req:        Request{Origin: "https://example.com"},
+			wantDenial: "CORS request from prohibited domain https://example.com",
+		},
+		{
+			name: "scheme domains",
+			config: Config{
+				SchemeDomains: map[string][]string{
+					"http": {"localhost:3000"},
+				},
+			},
+			req:             Request{Origin: "http://localhost:3000"},
+			wantAllowOrigin: "http://localhost:3000",
+		},
+		{
+			name: "prohibited scheme",
+			config: Config{
+				SchemeDomains: map[string][]string{
+					"http": {"localhost:3000"},
+				},
+			},
+			req:        Request{Origin: "https://localhost:3000"},
+			wantDenial: "CORS request from prohibited domain https://localhost:3000",
+		},
+		{
+			name: "schemes",
+			config: Config{
+				Scheme:      "http",
+				Schemes:     []string{"https", "capacitor"},
+				AllowDomain: []string{"localhost"},
+			},
+			req:             Request{Origin: "capacitor://localhost"},
+			wantAllowOrigin: "capacitor://localhost",
+		},
+		{
+			name: "prohibited by schemes",
+			config: Config{
+				Schemes:     []string{"https", "capacitor"},
+				AllowDomain: []string{"*"},
+			},
+			req:        Request{Origin: "http://localhost"},
+			wantDenial: "CORS request from prohibited domain http://localhost",
+		},
+		{
+			name: "wildcard with schemes",
+			config: Config{
+				Schemes:     []string{"https", "capacitor"},
+				AllowDomain: []string{"*"},
+			},
+			req:             Request{Origin: "capacitor://localhost"},
+			wantAllowOrigin: "*",
+		},
+		{
+			name:       "wildcard after other domains",
+			config:     Config{AllowDomain: []string{"example.com", "*"}},
+			req:        Request{Origin: "https://evil.com"},
+			wantDenial: "CORS request from prohibited domain https://evil.com",
+		},
+		{
+			name: "insecure origin",
+			config: Config{
+				AllowDomain:         []string{"*"},
+				RequireSecureOrigin: true,
+			},
+			req:        Request{Origin: "http://example.com"},
+			wantDenial: "CORS request from insecure origin http://example.com",
+		},
+		{
+			name: "insecure localhost",
+			config: Config{
+				AllowDomain:            []string{"[::1]:3000"},
+				RequireSecureOrigin:    true,
+				AllowInsecureLocalhost: true,
+			},
+			req:             Request{Origin: "http://[::1]:3000"},
+			wantAllowOrigin: "http://[::1]:3000",
+		},
+		{
+			name: "mismatched referer",
+			config: Config{
+				AllowDomain:  []string{"example.com"},
+				CheckReferer: true,
+			},
+			req: Request{
+				Origin:  "https://example.com",
+				Referer: "https://evil.com/",
+			},
+			wantDenial: "CORS request with mismatched referer https://evil.com/",
+		},
+		{
+			name: "canary selected",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Canary:      map[string]int{"partner.com": 100},
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "canary not selected",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Canary:      map[string]int{"partner.com": 0},
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "canary selected",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Canary:      map[string]int{"partner.com": 50},
+				Rand:        func(int) int { return 49 },
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "canary not selected by rand",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Canary:      map[string]int{"partner.com": 50},
+				Rand:        func(int) int { return 50 },
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "canary subdomain",
+			config: Config{
+				AllowDomain:    []string{"example.com"},
+				AllowSubdomain: true,
+				Canary:         map[string]int{"partner.com": 100},
+			},
+			req:             Request{Origin: "https://app.partner.com"},
+			wantAllowOrigin: "https://app.partner.com",
+		},
+		{
+			name: "gated enabled",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Gated:       map[string]func(context.Context) bool{"partner.com": func(context.Context) bool { return true }},
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "gated disabled",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				Gated:       map[string]func(context.Context) bool{"partner.com": func(context.Context) bool { return false }},
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "allow origin func",
+			config: Config{
+				AllowDomain:     []string{"example.com"},
+				AllowOriginFunc: func(_ context.Context, origin string) bool { return origin == "http://customer.com" },
+			},
+			req:             Request{Origin: "http://customer.com"},
+			wantAllowOrigin: "http://customer.com",
+		},
+		{
+			name: "allow origin func denied",
+			config: Config{
+				AllowDomain:     []string{"example.com"},
+				AllowOriginFunc: func(_ context.Context, origin string) bool { return origin == "http://customer.com" },
+			},
+			req:        Request{Origin: "https://example.com"},
+			wantDenial: "CORS request from prohibited domain https://example.com",
+		},
+		{
+			name: "origin pattern",
+			config: Config{
+				AllowDomain:         []string{"example.com"},
+				AllowOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`https://pr-\d+\.preview\.example\.com`)},
+			},
+			req:             Request{Origin: "https://pr-42.preview.example.com"},
+			wantAllowOrigin: "https://pr-42.preview.example.com",
+		},
+		{
+			name: "origin pattern anchored",
+			config: Config{
+				AllowDomain:         []string{"example.com"},
+				AllowOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`https://pr-\d+\.preview\.example\.com`)},
+			},
+			req:        Request{Origin: "https://pr-42.preview.example.com.evil.com"},
+			wantDenial: "CORS request from prohibited domain https://pr-42.preview.example.com.evil.com",
+		},
+		{
+			name: "allow origin func with secure origin",
+			config: Config{
+				RequireSecureOrigin: true,
+				AllowOriginFunc:     func(context.Context, string) bool { return true },
+			},
+			req:        Request{Origin: "http://customer.com"},
+			wantDenial: "CORS request from insecure origin http://customer.com",
+		},
+		{
+			name: "not expired",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Expires:     map[string]time.Time{"partner.com": time.Now().Add(time.Hour)},
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+			wantExpires:     true,
+		},
+		{
+			name: "expired",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Expires:     map[string]time.Time{"partner.com": time.Now().Add(-time.Hour)},
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "expired at clock",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Expires:     map[string]time.Time{"partner.com": time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)},
+				Clock:       func() time.Time { return time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC) },
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "within window at clock",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Windows: map[string][]Window{
+					"partner.com": {{Start: time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC), End: time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)}},
+				},
+				Clock: func() time.Time { return time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC) },
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "expired scheme domain",
+			config: Config{
+				SchemeDomains: map[string][]string{"https": {"partner.com"}},
+				Expires:       map[string]time.Time{"partner.com": time.Now().Add(-time.Hour)},
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "within window",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Windows: map[string][]Window{
+					"partner.com": {{Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}},
+				},
+			},
+			req:             Request{Origin: "https://partner.com"},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "outside window",
+			config: Config{
+				AllowDomain: []string{"example.com", "partner.com"},
+				Windows: map[string][]Window{
+					"partner.com": {
+						{End: time.Now().Add(-time.Hour)},
+						{Start: time.Now().Add(time.Hour)},
+					},
+				},
+			},
+			req:        Request{Origin: "https://partner.com"},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name:            "allowed fetch destination",
+			config:          Config{AllowDomain: []string{"example.com"}, AllowFetchDest: []string{"empty"}},
+			req:             Request{Origin: "https://example.com", FetchDest: "empty"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name:            "no fetch destination",
+			config:          Config{AllowDomain: []string{"example.com"}, AllowFetchDest: []string{"empty"}},
+			req:             Request{Origin: "https://example.com"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name:       "prohibited fetch destination",
+			config:     Config{AllowDomain: []string{"example.com"}, AllowFetchDest: []string{"empty"}},
+			req:        Request{FetchDest: "script"},
+			wantDenial: "Request with prohibited fetch destination script",
+		},
+		{
+			name:            "allowed headers",
+			config:          Config{AllowDomain: []string{"example.com"}, AllowHeaders: []string{"Content-Type"}},
+			req:             Request{Origin: "https://example.com", Headers: []string{"content-type"}},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name:            "any header",
+			config:          Config{AllowDomain: []string{"example.com"}, AllowHeaders: []string{"*"}},
+			req:             Request{Origin: "https://example.com", Headers: []string{"X-Token"}},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name:       "prohibited header",
+			config:     Config{AllowDomain: []string{"example.com"}, AllowHeaders: []string{"Content-Type"}},
+			req:        Request{Origin: "https://example.com", Headers: []string{"Content-Type", "X-Token"}},
+			wantDenial: "CORS request with prohibited header X-Token",
+		},
+		{
+			name:       "invalid origin",
+			config:     Config{AllowDomain: []string{"example.com"}},
+			req:        Request{Origin: "http://[::1"},
+			wantDenial: `Unable to parse CORS origin header: parse "http://[::1": missing ']' in host`,
+		},
+		{
+			name: "normalized origin",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				NormalizeOrigin: func(_ context.Context, origin string) (string, error) {
+					return strings.Replace(origin, "www.", "", 1), nil
+				},
+			},
+			req:             Request{Origin: "https://www.example.com"},
+			wantAllowOrigin: "https://www.example.com",
+		},
+		{
+			name: "origin rejected by normalization",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				NormalizeOrigin: func(context.Context, string) (string, error) {
+					return "", errors.New("vanity domain")
+				},
+			},
+			req:        Request{Origin: "https://www.example.com"},
+			wantDenial: "Unable to parse CORS origin header: vanity domain",
+		},
+		{
+			name:            "same host",
+			config:          Config{AllowDomain: []string{"example.com"}, AllowSameHost: true},
+			req:             Request{Origin: "http://localhost:3000", Host: "localhost:8080"},
+			wantAllowOrigin: "http://localhost:3000",
+		},
+		{
+			name:       "other host",
+			config:     Config{AllowDomain: []string{"example.com"}, AllowSameHost: true},
+			req:        Request{Origin: "http://evil.com", Host: "localhost:8080"},
+			wantDenial: "CORS request from prohibited domain http://evil.com",
+		},
+		{
+			name:            "server origin",
+			config:          Config{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			req:             Request{Origin: "https://api.test", ServerOrigin: "https://api.test"},
+			wantAllowOrigin: "https://api.test",
+		},
+		{
+			name:            "server origin non-CORS request",
+			config:          Config{AllowDomain: []string{"example.com"}, AlwaysAllowOrigin: true},
+			req:             Request{ServerOrigin: "https://api.test"},
+			wantAllowOrigin: "https://api.test",
+		},
+		{
+			name: "client certificate",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				AllowClientCertificate: func(context.Context, string, *tls.ConnectionState) bool {
+					return true
+				},
+			},
+			req: Request{
+				Origin: "https://partner.com",
+				TLS:    &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}},
+			},
+			wantAllowOrigin: "https://partner.com",
+		},
+		{
+			name: "unverified client certificate",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				AllowClientCertificate: func(context.Context, string, *tls.ConnectionState) bool {
+					return true
+				},
+			},
+			req:        Request{Origin: "https://partner.com", TLS: &tls.ConnectionState{}},
+			wantDenial: "CORS request from prohibited domain https://partner.com",
+		},
+		{
+			name: "allowed client IP",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				AllowClientIP: func(_ context.Context, _ string, ip net.IP) bool {
+					return ip.IsPrivate()
+				},
+			},
+			req:             Request{Origin: "https://example.com", ClientIP: "10.0.0.1"},
+			wantAllowOrigin: "https://example.com",
+		},
+		{
+			name: "prohibited client IP",
+			config: Config{
+				AllowDomain: []string{"example.com"},
+				AllowClientIP: func(_ context.Context, _ string, ip net.IP) bool {
+					return ip.IsPrivate()
+				},
+			},
+			req:        Request{Origin: "https://example.com", ClientIP: "203.0.113.1"},
+			wantDenial: "CORS request from prohibited domain https://example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := New(test.config).Evaluate(test.req)
+			assert.Equal(t, test.wantAllowOrigin, got.AllowOrigin)
+			assert.Equal(t, test.wantExpires, !got.Expires.IsZero())
+			if test.wantDenial == "" {
+				assert.Nil(t, got.Denial)
+				return
+			}
+			if assert.NotNil(t, got.Denial) {
+				assert.Equal(t, test.wantDenial, got.Denial.Message)
+				assert.NotEmpty(t, got.Denial.Code)
+			}
+		})
+	}
+}
+
+func TestRequest_Preflight(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want bool
+	}{
+		{
+			name: "preflight",
+			req:  Request{Origin: "https://example.com", Method: "OPTIONS", RequestMethod: "PUT"},
+			want: true,
+		},
+		{
+			name: "without origin",
+			req:  Request{Method: "OPTIONS", RequestMethod: "PUT"},
+		},
+		{
+			name: "without request method",
+			req:  Request{Origin: "https://example.com", Method: "OPTIONS"},
+		},
+		{
+			name: "actual request",
+			req:  Request{Origin: "https://example.com", Method: "PUT", RequestMethod: "PUT"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.req.Preflight())
+		})
+	}
+}
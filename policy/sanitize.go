@@ -0,0 +1,36 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxValueLength is the maximum length in bytes of a sanitized value before
+// truncation, which fits any valid origin.
+const maxValueLength = 256
+
+// Sanitize returns the request value for inclusion in client-visible messages
+// and plain-text logs. Values are attacker-controlled, thus non-printable
+// characters (e.g. line breaks that forge log entries) are escaped as in Go
+// string literals and values longer than 256 bytes are truncated with "...".
+func Sanitize(v string) string {
+	var b strings.Builder
+	for i, r := range v {
+		if i >= maxValueLength {
+			b.WriteString("...")
+			break
+		}
+		if unicode.IsPrint(r) {
+			b.WriteRune(r)
+			continue
+		}
+		q := strconv.QuoteRuneToASCII(r)
+		b.WriteString(q[1 : len(q)-1])
+	}
+	return b.String()
+}
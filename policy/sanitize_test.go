@@ -0,0 +1,33 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		v    string
+		want string
+	}{
+		{name: "empty", v: "", want: ""},
+		{name: "origin", v: "https://example.com", want: "https://example.com"},
+		{name: "unicode", v: "https://bücher.example", want: "https://bücher.example"},
+		{name: "line breaks", v: "https://a.com\r\nlevel=info msg=forged", want: `https://a.com\r\nlevel=info msg=forged`},
+		{name: "control", v: "https://a.com\x00\x1b[31m", want: `https://a.com\x00\x1b[31m`},
+		{name: "invalid UTF-8", v: "https://a.com\xff", want: `https://a.com�`},
+		{name: "truncated", v: "https://" + strings.Repeat("a", 300), want: "https://" + strings.Repeat("a", 248) + "..."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, Sanitize(test.v))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"time"
+)
+
+// Window is a period of time during which an allowlist entry is active. The
+// timestamps carry their own location, e.g. time.Date(2026, time.March, 7, 0,
+// 0, 0, 0, loc) for midnight in the partner's timezone.
+type Window struct {
+	// Start is the inclusive start of the window, zero for unbounded.
+	Start time.Time
+	// End is the exclusive end of the window, zero for unbounded.
+	End time.Time
+}
+
+// Contains returns true if the time is within the window.
+func (w Window) Contains(t time.Time) bool {
+	return (w.Start.IsZero() || !t.Before(w.Start)) &&
+		(w.End.IsZero() || t.Before(w.End))
+}
+
+// activeAt returns true if the time is within any of the windows, or there are
+// no windows.
+func activeAt(windows []Window, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow_Contains(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	start := time.Date(2026, time.March, 7, 0, 0, 0, 0, loc)
+	end := time.Date(2026, time.March, 9, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		name   string
+		window Window
+		t      time.Time
+		want   bool
+	}{
+		{name: "unbounded", window: Window{}, t: start, want: true},
+		{name: "at start", window: Window{Start: start, End: end}, t: start, want: true},
+		{name: "within", window: Window{Start: start, End: end}, t: start.Add(time.Hour), want: true},
+		{name: "at end", window: Window{Start: start, End: end}, t: end, want: false},
+		{name: "before start", window: Window{Start: start, End: end}, t: start.Add(-time.Nanosecond), want: false},
+		{name: "other timezone", window: Window{Start: start, End: end}, t: time.Date(2026, time.March, 6, 16, 0, 0, 0, time.UTC), want: true},
+		{name: "open end", window: Window{Start: start}, t: end.Add(24 * time.Hour), want: true},
+		{name: "open start", window: Window{End: end}, t: start.Add(-24 * time.Hour), want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.window.Contains(test.t))
+		})
+	}
+}
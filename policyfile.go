@@ -0,0 +1,163 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/flamego/flamego"
+)
+
+// allowedOrigin is a scheme and domain pair that is allowed by the options,
+// where the domain "*" stands for any domain.
+type allowedOrigin struct {
+	scheme string
+	domain string
+}
+
+// allowedOrigins returns the list of scheme and domain pairs that are allowed
+// by the options, in the order they are configured. Origins allowed by
+// AllowOriginFunc or TXTAllowlist are not known in advance and thus not
+// included.
+func allowedOrigins(opt Options) []allowedOrigin {
+	if opt.AllowOriginFunc != nil || opt.TXTAllowlist != nil {
+		return nil
+	}
+
+	var origins []allowedOrigin
+	anyDomain := allowAnyDomain(opt)
+	add := func(scheme string, domains []string) {
+		for _, d := range domains {
+			if d == "!*" || (d == "*" && anyDomain) {
+				origins = append(origins, allowedOrigin{scheme: scheme, domain: "*"})
+				continue
+			}
+			if d == "*" {
+				// Only a leading "*" is the wildcard, any other never matches
+				continue
+			}
+			origins = append(origins, allowedOrigin{scheme: scheme, domain: d})
+			if opt.AllowSubdomain {
+				origins = append(origins, allowedOrigin{scheme: scheme, domain: "*." + d})
+			}
+		}
+	}
+
+	if len(opt.SchemeDomains) > 0 {
+		schemes := make([]string, 0, len(opt.SchemeDomains))
+		for scheme := range opt.SchemeDomains {
+			schemes = append(schemes, scheme)
+		}
+		sort.Strings(schemes)
+		for _, scheme := range schemes {
+			add(scheme, opt.SchemeDomains[scheme])
+		}
+		return origins
+	}
+
+	schemes := []string{opt.Scheme}
+	switch {
+	case len(opt.Schemes) > 0:
+		schemes = opt.Schemes
+	case opt.Scheme == "*":
+		schemes = []string{"http", "https"}
+	}
+	for _, scheme := range schemes {
+		add(scheme, opt.AllowDomain)
+	}
+	return origins
+}
+
+// escapeXML returns the string escaped to be used as an XML attribute value.
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// CrossDomainXML returns a handler that serves a "crossdomain.xml" policy file
+// for legacy Flash clients, derived from the domains that are allowed by the
+// options. It is meant to be mounted at "/crossdomain.xml".
+func CrossDomainXML(options ...Options) flamego.Handler {
+	opt := prepareOptions(options)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0"?>` + "\n")
+	buf.WriteString(`<!DOCTYPE cross-domain-policy SYSTEM "http://www.adobe.com/xml/dtds/cross-domain-policy.dtd">` + "\n")
+	buf.WriteString("<cross-domain-policy>\n")
+	seen := make(map[string]bool)
+	for _, o := range allowedOrigins(opt) {
+		// Flash policies do not restrict ports of HTTP requests.
+		domain := o.domain
+		if host, _, err := net.SplitHostPort(domain); err == nil {
+			domain = host
+		}
+
+		secure := "false"
+		if o.scheme == "https" {
+			secure = "true"
+		}
+		entry := `  <allow-access-from domain="` + escapeXML(domain) + `" secure="` + secure + `"/>` + "\n"
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		buf.WriteString(entry)
+	}
+	buf.WriteString("</cross-domain-policy>\n")
+	body := buf.Bytes()
+
+	return func(c flamego.Context) {
+		c.ResponseWriter().Header().Set("Content-Type", "text/x-cross-domain-policy")
+		c.ResponseWriter().WriteHeader(http.StatusOK)
+		_, _ = c.ResponseWriter().Write(body)
+	}
+}
+
+// ClientAccessPolicyXML returns a handler that serves a
+// "clientaccesspolicy.xml" policy file for legacy Silverlight clients, derived
+// from the domains that are allowed by the options. It is meant to be mounted
+// at "/clientaccesspolicy.xml".
+func ClientAccessPolicyXML(options ...Options) flamego.Handler {
+	opt := prepareOptions(options)
+
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	buf.WriteString("<access-policy>\n")
+	buf.WriteString("  <cross-domain-access>\n")
+	buf.WriteString("    <policy>\n")
+	buf.WriteString(`      <allow-from http-request-headers="*">` + "\n")
+	seen := make(map[string]bool)
+	for _, o := range allowedOrigins(opt) {
+		uri := o.scheme + "://" + o.domain
+		if o.domain == "*" && o.scheme == "http" {
+			// Silverlight reads the bare wildcard as any HTTP origin.
+			uri = "*"
+		}
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		buf.WriteString(`        <domain uri="` + escapeXML(uri) + `"/>` + "\n")
+	}
+	buf.WriteString("      </allow-from>\n")
+	buf.WriteString("      <grant-to>\n")
+	buf.WriteString(`        <resource path="/" include-subpaths="true"/>` + "\n")
+	buf.WriteString("      </grant-to>\n")
+	buf.WriteString("    </policy>\n")
+	buf.WriteString("  </cross-domain-access>\n")
+	buf.WriteString("</access-policy>\n")
+	body := buf.Bytes()
+
+	return func(c flamego.Context) {
+		c.ResponseWriter().Header().Set("Content-Type", "text/xml; charset=utf-8")
+		c.ResponseWriter().WriteHeader(http.StatusOK)
+		_, _ = c.ResponseWriter().Write(body)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestCrossDomainXML(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		wantBody string
+	}{
+		{
+			name:    "default",
+			options: Options{},
+			wantBody: `<?xml version="1.0"?>
+<!DOCTYPE cross-domain-policy SYSTEM "http://www.adobe.com/xml/dtds/cross-domain-policy.dtd">
+<cross-domain-policy>
+  <allow-access-from domain="*" secure="false"/>
+</cross-domain-policy>
+`,
+		},
+		{
+			name: "domains",
+			options: Options{
+				Scheme:         "https",
+				AllowDomain:    []string{"example.com", "example.com:8080"},
+				AllowSubdomain: true,
+			},
+			wantBody: `<?xml version="1.0"?>
+<!DOCTYPE cross-domain-policy SYSTEM "http://www.adobe.com/xml/dtds/cross-domain-policy.dtd">
+<cross-domain-policy>
+  <allow-access-from domain="example.com" secure="true"/>
+  <allow-access-from domain="*.example.com" secure="true"/>
+</cross-domain-policy>
+`,
+		},
+		{
+			name: "scheme domains",
+			options: Options{
+				SchemeDomains: map[string][]string{
+					"https": {"example.com"},
+					"http":  {"localhost:3000"},
+				},
+			},
+			wantBody: `<?xml version="1.0"?>
+<!DOCTYPE cross-domain-policy SYSTEM "http://www.adobe.com/xml/dtds/cross-domain-policy.dtd">
+<cross-domain-policy>
+  <allow-access-from domain="localhost" secure="false"/>
+  <allow-access-from domain="example.com" secure="true"/>
+</cross-domain-policy>
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Get("/crossdomain.xml", CrossDomainXML(test.options))
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/crossdomain.xml", nil)
+			assert.Nil(t, err)
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, "text/x-cross-domain-policy", resp.Header().Get("Content-Type"))
+			assert.Equal(t, test.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestClientAccessPolicyXML(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     Options
+		wantDomains string
+	}{
+		{
+			name:    "default",
+			options: Options{},
+			wantDomains: `        <domain uri="*"/>
+`,
+		},
+		{
+			name: "domains",
+			options: Options{
+				Scheme:         "*",
+				AllowDomain:    []string{"example.com:8080"},
+				AllowSubdomain: true,
+			},
+			wantDomains: `        <domain uri="http://example.com:8080"/>
+        <domain uri="http://*.example.com:8080"/>
+        <domain uri="https://example.com:8080"/>
+        <domain uri="https://*.example.com:8080"/>
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Get("/clientaccesspolicy.xml", ClientAccessPolicyXML(test.options))
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/clientaccesspolicy.xml", nil)
+			assert.Nil(t, err)
+
+			f.ServeHTTP(resp, req)
+
+			want := `<?xml version="1.0" encoding="utf-8"?>
+<access-policy>
+  <cross-domain-access>
+    <policy>
+      <allow-from http-request-headers="*">
+` + test.wantDomains + `      </allow-from>
+      <grant-to>
+        <resource path="/" include-subpaths="true"/>
+      </grant-to>
+    </policy>
+  </cross-domain-access>
+</access-policy>
+`
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, "text/xml; charset=utf-8", resp.Header().Get("Content-Type"))
+			assert.Equal(t, want, resp.Body.String())
+		})
+	}
+}
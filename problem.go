@@ -0,0 +1,36 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemTypePrefix is the prefix of the "type" member of Problem Details,
+// which is followed by the code of the denial reason, e.g.
+// "tag:flamego.dev,2021:cors:prohibited_domain".
+const ProblemTypePrefix = "tag:flamego.dev,2021:cors:"
+
+// problem is a Problem Details object of a denial, see RFC 7807.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+	Origin   string `json:"origin,omitempty"`
+	// Reason and Hint are only included with DebugErrorPage.
+	Reason string `json:"reason,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// writeProblem writes the Problem Details of a denial.
+func writeProblem(w http.ResponseWriter, p problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/flamego/flamego"
+)
+
+// NewProxy returns a handler that reverse proxies requests to the target while
+// applying the CORS policy of the options. Any "Access-Control-*" and
+// "Timing-Allow-Origin" headers sent by the upstream are stripped, as well as
+// the entries of its "Vary" header that the policy decides on, e.g. "Origin",
+// so only the configured policy reaches the browser. Other "Vary" entries of
+// the upstream are merged with those of the policy. Preflight requests are
+// answered without contacting the upstream.
+func NewProxy(target *url.URL, options ...Options) flamego.Handler {
+	h := newHandler(options...)
+	managed := append([]string{HeaderAccessControlRequestMethod, HeaderAccessControlRequestHeaders}, h.vary...)
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		deleteCORSHeaders(resp.Header)
+		removeVary(resp.Header, managed...)
+		return nil
+	}
+
+	return flamego.LoggerInvoker(func(ctx flamego.Context, logger *log.Logger) {
+		h.serve(ctx, logger, func() {
+			// The upstream entries are added as another "Vary" header
+			ctx.ResponseWriter().Before(func(w flamego.ResponseWriter) {
+				removeVary(w.Header())
+			})
+			proxy.ServeHTTP(ctx.ResponseWriter(), ctx.Request().Request)
+		})
+	})
+}
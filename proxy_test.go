@@ -0,0 +1,131 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestNewProxy(t *testing.T) {
+	var upstreamCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Secret")
+		w.Header().Set("Timing-Allow-Origin", "*")
+		w.Header().Set("Vary", "Origin, Accept-Encoding")
+		w.Header().Set("X-Upstream", "1")
+		_, _ = w.Write([]byte(responseBody))
+	}))
+	defer upstream.Close()
+
+	target, err := url.Parse(upstream.URL)
+	assert.Nil(t, err)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Any("/{**}", NewProxy(target, Options{
+		Scheme:           "https",
+		AllowDomain:      []string{"example.com"},
+		AllowCredentials: true,
+	}))
+
+	tests := []struct {
+		name             string
+		method           string
+		reqHeaders       map[string]string
+		wantCode         int
+		wantHeaders      map[string]string
+		wantResponseBody string
+		wantVary         []string
+		wantUpstream     bool
+	}{
+		{
+			name:   "allowed",
+			method: http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "https://example.com",
+			},
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "",
+				"Timing-Allow-Origin":              "",
+				"X-Upstream":                       "1",
+			},
+			wantVary:         []string{"Origin,Accept-Encoding"},
+			wantResponseBody: responseBody,
+			wantUpstream:     true,
+		},
+		{
+			name:     "same-origin",
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Timing-Allow-Origin":         "",
+				"X-Upstream":                  "1",
+			},
+			wantVary:         []string{"Origin,Accept-Encoding"},
+			wantResponseBody: responseBody,
+			wantUpstream:     true,
+		},
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			reqHeaders: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": http.MethodPut,
+			},
+			wantCode: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "https://example.com",
+				"X-Upstream":                  "",
+			},
+		},
+		{
+			name:   "prohibited",
+			method: http.MethodGet,
+			reqHeaders: map[string]string{
+				"Origin": "https://evil.com",
+			},
+			wantCode:         http.StatusBadRequest,
+			wantResponseBody: "CORS request from prohibited domain https://evil.com\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&upstreamCalls, 0)
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, "/api", nil)
+			assert.Nil(t, err)
+			for k, v := range test.reqHeaders {
+				req.Header.Set(k, v)
+			}
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantResponseBody, resp.Body.String())
+			assert.Equal(t, test.wantUpstream, atomic.LoadInt32(&upstreamCalls) > 0)
+			for k, v := range test.wantHeaders {
+				assert.Equal(t, v, resp.Header().Get(k), k)
+			}
+			if test.wantVary != nil {
+				assert.Equal(t, test.wantVary, resp.Header().Values("Vary"))
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuotaStore counts requests of quota keys in fixed time windows, e.g. backed
+// by Redis to share quotas across instances, see Options.Quota for the keys.
+// Implementations must be safe for concurrent use.
+type QuotaStore interface {
+	// Increment adds a request of the key to its current window of the given
+	// length, and returns the number of requests in the window, including this
+	// one, and when the window resets. The context is the context of the request.
+	Increment(ctx context.Context, key string, window time.Duration) (n int, reset time.Time, err error)
+}
+
+// quotaCounter is the request count of a key in its current window.
+type quotaCounter struct {
+	n     int
+	reset time.Time
+}
+
+// MemoryQuotaStore is an in-memory QuotaStore, it is safe for concurrent use.
+type MemoryQuotaStore struct {
+	// Clock returns the current time. Default is time.Now.
+	Clock func() time.Time
+	// MaxKeys is the maximum number of keys that are counted in their current
+	// windows, requests of further keys fail to be counted until windows reset.
+	// Default is 10000.
+	MaxKeys int
+
+	mu       sync.Mutex
+	counters map[string]*quotaCounter
+	// sweep is when the counters of past windows are next removed.
+	sweep time.Time
+}
+
+var _ QuotaStore = (*MemoryQuotaStore)(nil)
+
+// NewMemoryQuotaStore returns a new MemoryQuotaStore.
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		counters: make(map[string]*quotaCounter),
+	}
+}
+
+// Increment adds a request of the key to its current window of the given
+// length, and returns the number of requests in the window and when the window
+// resets. It returns an error if MaxKeys other keys are counted.
+func (s *MemoryQuotaStore) Increment(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	if s.Clock != nil {
+		now = s.Clock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maxKeys := s.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 10000
+	}
+	_, ok := s.counters[key]
+	if !now.Before(s.sweep) || (!ok && len(s.counters) >= maxKeys) {
+		for k, c := range s.counters {
+			if !now.Before(c.reset) {
+				delete(s.counters, k)
+			}
+		}
+		s.sweep = now.Add(window)
+	}
+
+	c, ok := s.counters[key]
+	if !ok && len(s.counters) >= maxKeys {
+		return 0, time.Time{}, errors.Errorf("too many quota keys (%d)", maxKeys)
+	}
+	if !ok || !now.Before(c.reset) {
+		c = &quotaCounter{reset: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.n++
+	return c.n, c.reset, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryQuotaStore(t *testing.T) {
+	s := NewMemoryQuotaStore()
+
+	for i := 1; i <= 3; i++ {
+		n, reset, err := s.Increment(context.Background(), "https://example.com", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, i, n)
+		assert.True(t, reset.After(time.Now()))
+	}
+
+	n, _, err := s.Increment(context.Background(), "https://example.org", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	now := time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)
+	s.Clock = func() time.Time { return now }
+	n, reset, err := s.Increment(context.Background(), "https://example.net", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, now.Add(time.Minute), reset)
+	now = now.Add(time.Minute)
+	n, _, err = s.Increment(context.Background(), "https://example.net", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	t.Run("max keys", func(t *testing.T) {
+		now := time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC)
+		s := NewMemoryQuotaStore()
+		s.Clock = func() time.Time { return now }
+		s.MaxKeys = 2
+
+		for _, key := range []string{"a", "b", "a"} {
+			_, _, err := s.Increment(context.Background(), key, time.Minute)
+			assert.Nil(t, err)
+		}
+		_, _, err := s.Increment(context.Background(), "c", time.Minute)
+		assert.EqualError(t, err, "too many quota keys (2)")
+
+		// Keys of past windows make room
+		now = now.Add(time.Minute)
+		_, _, err = s.Increment(context.Background(), "c", time.Minute)
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/flamego/flamego"
+)
+
+// Recording is a CORS request and the decision made by the middleware.
+type Recording struct {
+	// Origin is the origin of the request, as read from the "Origin" request
+	// header or Options.OriginHeader.
+	Origin string `json:"origin"`
+	// Method is the method of the request.
+	Method string `json:"method"`
+	// Host is the host of the request.
+	Host string `json:"host,omitempty"`
+	// Path is the URL path of the request.
+	Path string `json:"path,omitempty"`
+	// TLS indicates whether the request was received over TLS.
+	TLS bool `json:"tls,omitempty"`
+	// RequestMethod is the value of the "Access-Control-Request-Method" request
+	// header.
+	RequestMethod string `json:"request_method,omitempty"`
+	// RequestHeaders is the value of the "Access-Control-Request-Headers" request
+	// header.
+	RequestHeaders string `json:"request_headers,omitempty"`
+	// Allowed indicates whether the request was allowed.
+	Allowed bool `json:"allowed"`
+	// Headers contains the emitted CORS response headers.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Recorder writes recordings of CORS requests as JSON lines, it is safe for
+// concurrent use.
+type Recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewRecorder returns a new Recorder that writes to the given writer, e.g. an
+// *os.File.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// record writes the recording of the request with the given origin and emitted
+// CORS response headers.
+func (r *Recorder) record(req *http.Request, origin string, headers map[string]string) {
+	rec := Recording{
+		Origin:         origin,
+		Method:         req.Method,
+		Host:           req.Host,
+		Path:           req.URL.Path,
+		TLS:            req.TLS != nil,
+		RequestMethod:  req.Header.Get(HeaderAccessControlRequestMethod),
+		RequestHeaders: strings.Join(req.Header.Values(HeaderAccessControlRequestHeaders), ","),
+		Allowed:        headers[HeaderAccessControlAllowOrigin] != "",
+	}
+	if len(headers) > 0 {
+		rec.Headers = make(map[string]string, len(headers))
+		for k, v := range headers {
+			rec.Headers[k] = v
+		}
+	}
+
+	p, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, _ = r.w.Write(append(p, '\n'))
+}
+
+// Mismatch is a recording that is decided differently by the replayed
+// configuration.
+type Mismatch struct {
+	// Recording is the recorded request and decision.
+	Recording Recording
+	// Got is the decision made by the replayed configuration.
+	Got Recording
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s %s: recorded allowed=%v headers=%v, got allowed=%v headers=%v",
+		m.Recording.Method, m.Recording.Origin,
+		m.Recording.Allowed, m.Recording.Headers,
+		m.Got.Allowed, m.Got.Headers,
+	)
+}
+
+// Replay replays recordings read from the reader, as written by a Recorder,
+// against the middleware with the given options, and returns the recordings
+// that are decided differently. It is meant for regression tests that guard
+// the policy against accidental changes. The recorded origin is replayed in the
+// "Origin" request header, along with the recorded host, path and TLS state.
+func Replay(r io.Reader, options Options) ([]Mismatch, error) {
+	f := flamego.NewWithLogger(io.Discard)
+	f.Use(CORS(options))
+	f.Any("/{**}", func(c flamego.Context) {
+		c.ResponseWriter().WriteHeader(http.StatusNoContent)
+	})
+
+	var mismatches []Mismatch
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var want Recording
+		err := json.Unmarshal(line, &want)
+		if err != nil {
+			return nil, errors.Wrap(err, "unmarshal recording")
+		}
+
+		req, err := http.NewRequest(want.Method, "/", nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "new request")
+		}
+		if want.Path != "" {
+			req.URL.Path = want.Path
+		}
+		req.Host = want.Host
+		if want.TLS {
+			req.TLS = &tls.ConnectionState{}
+		}
+		req.Header.Set(HeaderOrigin, want.Origin)
+		if want.RequestMethod != "" {
+			req.Header.Set(HeaderAccessControlRequestMethod, want.RequestMethod)
+		}
+		if want.RequestHeaders != "" {
+			req.Header.Set(HeaderAccessControlRequestHeaders, want.RequestHeaders)
+		}
+
+		resp := httptest.NewRecorder()
+		f.ServeHTTP(resp, req)
+
+		got := want
+		got.Headers = nil
+		for k := range resp.Header() {
+			if k == "Vary" || strings.HasPrefix(k, "Access-Control-") {
+				if got.Headers == nil {
+					got.Headers = make(map[string]string)
+				}
+				got.Headers[k] = resp.Header().Get(k)
+			}
+		}
+		got.Allowed = got.Headers[HeaderAccessControlAllowOrigin] != ""
+
+		if got.Allowed != want.Allowed || !reflect.DeepEqual(got.Headers, want.Headers) {
+			mismatches = append(mismatches, Mismatch{
+				Recording: want,
+				Got:       got,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read recordings")
+	}
+	return mismatches, nil
+}
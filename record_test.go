@@ -0,0 +1,184 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	options := Options{
+		Scheme:         "https",
+		AllowDomain:    []string{"example.com"},
+		AllowSubdomain: true,
+	}
+	recorded := options
+	recorded.Recorder = NewRecorder(&buf)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(recorded))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	requests := []struct {
+		method  string
+		headers map[string]string
+	}{
+		{
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://a.example.com",
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "Content-Type",
+			},
+		},
+		{
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+		},
+		{
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Origin": "https://evil.com",
+			},
+		},
+		{
+			method: http.MethodGet,
+		},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, "/", nil)
+		assert.Nil(t, err)
+		for k, v := range r.headers {
+			req.Header.Set(k, v)
+		}
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+
+	t.Run("same options", func(t *testing.T) {
+		mismatches, err := Replay(bytes.NewReader(buf.Bytes()), options)
+		assert.Nil(t, err)
+		assert.Empty(t, mismatches)
+	})
+
+	t.Run("tightened options", func(t *testing.T) {
+		tightened := options
+		tightened.AllowSubdomain = false
+		mismatches, err := Replay(bytes.NewReader(buf.Bytes()), tightened)
+		assert.Nil(t, err)
+		if assert.Len(t, mismatches, 1) {
+			assert.Equal(t, "https://a.example.com", mismatches[0].Recording.Origin)
+			assert.True(t, mismatches[0].Recording.Allowed)
+			assert.False(t, mismatches[0].Got.Allowed)
+		}
+	})
+
+	t.Run("changed headers", func(t *testing.T) {
+		changed := options
+		changed.AllowCredentials = true
+		mismatches, err := Replay(bytes.NewReader(buf.Bytes()), changed)
+		assert.Nil(t, err)
+		assert.Len(t, mismatches, 2)
+	})
+
+	t.Run("invalid recording", func(t *testing.T) {
+		_, err := Replay(strings.NewReader("{"), options)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRecordReplay_Request(t *testing.T) {
+	var buf bytes.Buffer
+	options := Options{
+		Scheme:         "https",
+		AllowDomain:    []string{"example.com"},
+		AllowSameHost:  true,
+		OriginHeader:   "X-Original-Origin",
+		TrustedProxies: []string{"10.0.0.1"},
+		ExemptPaths:    []string{"/"},
+	}
+	recorded := options
+	recorded.Recorder = NewRecorder(&buf)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(recorded))
+	f.Get("/{**}", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	requests := []struct {
+		host       string
+		remoteAddr string
+		tls        bool
+		headers    map[string]string
+	}{
+		{
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Origin":            "https://proxy.internal",
+				"X-Original-Origin": "https://example.com",
+			},
+		},
+		{
+			host: "localhost:8080",
+			tls:  true,
+			headers: map[string]string{
+				"Origin": "https://localhost:3000",
+			},
+		},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(http.MethodGet, "/api", nil)
+		assert.Nil(t, err)
+		if r.host != "" {
+			req.Host = r.host
+		}
+		req.RemoteAddr = r.remoteAddr
+		if r.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for k, v := range r.headers {
+			req.Header.Set(k, v)
+		}
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var recordings []Recording
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec Recording
+		assert.Nil(t, json.Unmarshal([]byte(line), &rec))
+		recordings = append(recordings, rec)
+	}
+	if assert.Len(t, recordings, 2) {
+		assert.Equal(t, "https://example.com", recordings[0].Origin)
+		assert.Equal(t, "/api", recordings[0].Path)
+		assert.False(t, recordings[0].TLS)
+		assert.True(t, recordings[0].Allowed)
+
+		assert.Equal(t, "localhost:8080", recordings[1].Host)
+		assert.True(t, recordings[1].TLS)
+		assert.True(t, recordings[1].Allowed)
+	}
+
+	mismatches, err := Replay(bytes.NewReader(buf.Bytes()), options)
+	assert.Nil(t, err)
+	assert.Empty(t, mismatches)
+}
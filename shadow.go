@@ -0,0 +1,121 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/flamego/cors/policy"
+)
+
+// ShadowMismatch is a request that is decided differently by the shadow policy
+// and the active policy.
+type ShadowMismatch struct {
+	// Origin is the value of the "Origin" request header.
+	Origin string
+	// Method is the method of the request.
+	Method string
+	// Path is the URL path of the request.
+	Path string
+	// Allowed indicates whether the request is allowed by the active policy.
+	Allowed bool
+	// ShadowAllowed indicates whether the request is allowed by the shadow
+	// policy.
+	ShadowAllowed bool
+}
+
+// Shadow is a candidate policy that is evaluated alongside the active policy
+// purely for comparison, to de-risk changes of the allowlist. Requests are
+// always decided by the active policy. A Shadow may be shared by multiple
+// middleware, in which case its counts add up the mismatches of all of them.
+type Shadow struct {
+	// Options contains options of the candidate policy, only the options that
+	// decide the origin are consulted. The hooks AllowOriginFunc, TXTAllowlist,
+	// NormalizeOrigin, AllowClientCertificate and Flags are never run, so that
+	// the comparison has no side effects, and requests that the candidate policy
+	// could only decide by running one of them are not compared. AllowClientIP is
+	// run with the client IP of the request, so it must have no side effects.
+	Options Options
+	// OnMismatch is called for every mismatched request. When not set, mismatches
+	// are logged as warnings.
+	OnMismatch func(ctx context.Context, m ShadowMismatch)
+
+	deniedByShadow  uint64
+	allowedByShadow uint64
+}
+
+// Mismatches returns the number of requests that are allowed by the active
+// policy but denied by the shadow policy, and vice versa.
+func (s *Shadow) Mismatches() (deniedByShadow, allowedByShadow uint64) {
+	return atomic.LoadUint64(&s.deniedByShadow), atomic.LoadUint64(&s.allowedByShadow)
+}
+
+// shadowHookKey is the context key of the flag that is set when the shadow
+// policy consults a hook that it does not run.
+type shadowHookKey struct{}
+
+// markShadowHook sets the flag of the context that the shadow policy consults a
+// hook.
+func markShadowHook(ctx context.Context) {
+	if hooked, ok := ctx.Value(shadowHookKey{}).(*bool); ok {
+		*hooked = true
+	}
+}
+
+// shadowConfig returns the configuration of the shadow policy of the options,
+// whose hooks are replaced by ones that only mark the evaluation, see
+// Shadow.Options.
+func shadowConfig(opt Options) policy.Config {
+	config := policyConfig(opt)
+	for d := range config.Gated {
+		config.Gated[d] = func(ctx context.Context) bool {
+			markShadowHook(ctx)
+			return false
+		}
+	}
+	if config.AllowOriginFunc != nil {
+		config.AllowOriginFunc = func(ctx context.Context, _ string) bool {
+			markShadowHook(ctx)
+			return false
+		}
+	}
+	if config.NormalizeOrigin != nil {
+		config.NormalizeOrigin = func(ctx context.Context, origin string) (string, error) {
+			markShadowHook(ctx)
+			return origin, nil
+		}
+	}
+	if config.AllowClientCertificate != nil {
+		config.AllowClientCertificate = func(ctx context.Context, _ string, _ *tls.ConnectionState) bool {
+			markShadowHook(ctx)
+			return false
+		}
+	}
+	return config
+}
+
+func (s *Shadow) report(ctx context.Context, logger *log.Logger, m ShadowMismatch) {
+	if m.Allowed {
+		atomic.AddUint64(&s.deniedByShadow, 1)
+	} else {
+		atomic.AddUint64(&s.allowedByShadow, 1)
+	}
+
+	if s.OnMismatch != nil {
+		s.OnMismatch(ctx, m)
+		return
+	}
+	logger.WithPrefix("cors").Warn("Shadow policy mismatch",
+		"method", m.Method,
+		"path", m.Path,
+		"origin", m.Origin,
+		"allowed", m.Allowed,
+		"shadow_allowed", m.ShadowAllowed,
+	)
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestShadow(t *testing.T) {
+	var got []ShadowMismatch
+	shadow := &Shadow{
+		Options: Options{
+			AllowDomain: []string{"example.com", "new.example.com"},
+		},
+		OnMismatch: func(_ context.Context, m ShadowMismatch) {
+			got = append(got, m)
+		},
+	}
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		AllowSubdomain: true,
+		Shadow:         shadow,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	for _, origin := range []string{
+		"http://example.com",
+		"http://new.example.com",
+		"http://old.example.com",
+		"http://evil.com",
+		"",
+	} {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		f.ServeHTTP(resp, req)
+	}
+
+	want := []ShadowMismatch{
+		{
+			Origin:        "http://old.example.com",
+			Method:        http.MethodGet,
+			Path:          "/",
+			Allowed:       true,
+			ShadowAllowed: false,
+		},
+	}
+	assert.Equal(t, want, got)
+
+	deniedByShadow, allowedByShadow := shadow.Mismatches()
+	assert.Equal(t, uint64(1), deniedByShadow)
+	assert.Equal(t, uint64(0), allowedByShadow)
+}
+
+func TestShadow_Log(t *testing.T) {
+	var buf bytes.Buffer
+	f := flamego.NewWithLogger(&buf)
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		Shadow: &Shadow{
+			Options: Options{
+				AllowDomain: []string{"example.com", "evil.com"},
+			},
+		},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://evil.com")
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, buf.String(), "Shadow policy mismatch")
+}
+
+func TestShadow_Hooks(t *testing.T) {
+	var calls int
+	allowOriginFunc := func(_ context.Context, origin string) bool {
+		calls++
+		return origin == "http://func.com"
+	}
+	shadow := &Shadow{
+		Options: Options{
+			AllowDomain:     []string{"example.com"},
+			AllowOriginFunc: allowOriginFunc,
+		},
+	}
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowOriginFunc: allowOriginFunc,
+		Shadow:          shadow,
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.Nil(t, err)
+	req.Header.Set("Origin", "http://func.com")
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 1, calls)
+	deniedByShadow, allowedByShadow := shadow.Mismatches()
+	assert.Equal(t, uint64(0), deniedByShadow)
+	assert.Equal(t, uint64(0), allowedByShadow)
+}
+
+func TestShadow_ClientIP(t *testing.T) {
+	shadow := &Shadow{
+		Options: Options{
+			AllowDomain: []string{"example.com"},
+			AllowClientIP: func(_ context.Context, _ string, ip net.IP) bool {
+				return !ip.Equal(net.ParseIP("10.0.0.2"))
+			},
+		},
+	}
+
+	// The same Shadow is shared by both middleware
+	for i := 0; i < 2; i++ {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{
+			AllowDomain: []string{"example.com"},
+			Shadow:      shadow,
+		}))
+		f.Get("/", func(c flamego.Context) string {
+			return responseBody
+		})
+
+		for _, remoteAddr := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.RemoteAddr = remoteAddr
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+		}
+	}
+
+	deniedByShadow, allowedByShadow := shadow.Mismatches()
+	assert.Equal(t, uint64(2), deniedByShadow)
+	assert.Equal(t, uint64(0), allowedByShadow)
+}
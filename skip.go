@@ -0,0 +1,53 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/flamego/flamego"
+)
+
+// Skip opts the response out of CORS decoration by removing the
+// "Access-Control-*" and "Timing-Allow-Origin" headers and the entries of the
+// "Vary" header that were added by the middleware, e.g. for an internal
+// endpoint that is reached through a catch-all route. It must be called before
+// the response is written.
+func Skip(c flamego.Context) {
+	header := c.ResponseWriter().Header()
+	deleteCORSHeaders(header)
+
+	v := c.Value(reflect.TypeOf((*addedVary)(nil)))
+	if !v.IsValid() {
+		return
+	}
+	if vary, ok := v.Interface().(*addedVary); ok {
+		removeVary(header, vary.names...)
+	}
+}
+
+// deleteCORSHeaders removes the "Access-Control-*" and "Timing-Allow-Origin"
+// headers.
+func deleteCORSHeaders(header http.Header) {
+	for k := range header {
+		if strings.HasPrefix(k, "Access-Control-") {
+			header.Del(k)
+		}
+	}
+	header.Del(HeaderTimingAllowOrigin)
+}
+
+// Exempt returns a handler that marks the route as exempt from the globally
+// registered CORS middleware by calling Skip, so the responses of the route
+// carry no CORS headers. It must be placed before the handler that writes the
+// response. Preflight requests run the middleware before they reach a route,
+// so list the path in Options.ExemptPaths as well to pass them through.
+func Exempt() flamego.Handler {
+	return func(c flamego.Context) {
+		Skip(c)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSkip(t *testing.T) {
+	tests := []struct {
+		name        string
+		opt         Options
+		handlerVary string
+		wantVary    string
+	}{
+		{
+			name:        "allowlist",
+			opt:         Options{AllowDomain: []string{"example.com"}, AllowCredentials: true},
+			handlerVary: "Accept-Encoding",
+			wantVary:    "Accept-Encoding",
+		},
+		{
+			name: "wildcard",
+			opt:  Options{},
+		},
+		{
+			name: "origin header",
+			opt: Options{
+				AllowDomain:    []string{"example.com"},
+				OriginHeader:   "X-Original-Origin",
+				TrustedProxies: []string{"192.0.2.1"},
+				VaryHeaders:    []string{"X-Tenant"},
+			},
+			handlerVary: "Accept-Encoding, Cookie",
+			wantVary:    "Accept-Encoding,Cookie",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			f := flamego.NewWithLogger(&buf)
+			f.Use(CORS(test.opt))
+			f.Any("/{**}", func(c flamego.Context) string {
+				if test.handlerVary != "" {
+					c.ResponseWriter().Header().Add("Vary", test.handlerVary)
+				}
+				Skip(c)
+				c.SetCookie(http.Cookie{Name: "session", Value: "1"})
+				return responseBody
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/internal/metrics", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, responseBody, resp.Body.String())
+			for k := range resp.Header() {
+				assert.False(t, strings.HasPrefix(k, "Access-Control-"), k)
+			}
+			assert.Equal(t, test.wantVary, resp.Header().Get("Vary"))
+			assert.NotContains(t, buf.String(), "Response sets cookies under the wildcard origin policy")
+		})
+	}
+}
+
+func TestExempt(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{AllowDomain: []string{"example.com"}}))
+	f.Get("/", func() string {
+		return responseBody
+	})
+	f.Get("/internal", Exempt(), func() string {
+		return responseBody
+	})
+
+	tests := []struct {
+		path            string
+		wantAllowOrigin string
+	}{
+		{path: "/", wantAllowOrigin: "http://example.com"},
+		{path: "/internal", wantAllowOrigin: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, test.path, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, responseBody, resp.Body.String())
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestExemptPaths(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain: []string{"example.com"},
+		ExemptPaths: []string{"/internal/*"},
+	}))
+	f.Get("/api", func() string {
+		return responseBody
+	})
+	f.Get("/internal/status", func() string {
+		return responseBody
+	})
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{name: "actual", method: http.MethodGet, path: "/api", wantCode: http.StatusOK, wantAllowOrigin: "http://example.com"},
+		{name: "preflight", method: http.MethodOptions, path: "/api", wantCode: http.StatusNoContent, wantAllowOrigin: "http://example.com"},
+		{name: "exempt actual", method: http.MethodGet, path: "/internal/status", wantCode: http.StatusOK},
+		{name: "exempt preflight", method: http.MethodOptions, path: "/internal/status", wantCode: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, test.path, nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+			req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+		})
+	}
+
+	assert.PanicsWithValue(t, "cors: invalid ExemptPaths pattern /[: syntax error in pattern", func() {
+		CORS(Options{ExemptPaths: []string{"/["}})
+	})
+}
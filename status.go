@@ -0,0 +1,60 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"net/http"
+
+	"github.com/flamego/flamego"
+)
+
+// statusWriter is a flamego.ResponseWriter that removes the "Access-Control-*"
+// headers when the response is written with a status code that is not
+// decorated, see Options.DecorateStatus and Options.SkipStatus.
+type statusWriter struct {
+	flamego.ResponseWriter
+	opt Options
+}
+
+// decorates returns true if responses with the status code receive CORS
+// headers.
+func (w *statusWriter) decorates(code int) bool {
+	for _, c := range w.opt.SkipStatus {
+		if c == code {
+			return false
+		}
+	}
+	if len(w.opt.DecorateStatus) == 0 {
+		return true
+	}
+	for _, c := range w.opt.DecorateStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.Written() && !w.decorates(code) {
+		// "Vary" is kept since the response still depends on the origin
+		deleteCORSHeaders(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if !w.Written() {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.ResponseWriter.Flush()
+}
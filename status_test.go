@@ -0,0 +1,112 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestDecorateStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		opt             Options
+		handler         flamego.Handler
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{
+			name:            "default",
+			opt:             Options{AllowDomain: []string{"example.com"}},
+			handler:         func() (int, string) { return http.StatusUnauthorized, responseBody },
+			wantCode:        http.StatusUnauthorized,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name:     "skipped",
+			opt:      Options{AllowDomain: []string{"example.com"}, SkipStatus: []int{http.StatusUnauthorized}},
+			handler:  func() (int, string) { return http.StatusUnauthorized, responseBody },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:            "not skipped",
+			opt:             Options{AllowDomain: []string{"example.com"}, SkipStatus: []int{http.StatusUnauthorized}},
+			handler:         func() string { return responseBody },
+			wantCode:        http.StatusOK,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name: "decorated",
+			opt: Options{
+				AllowDomain:    []string{"example.com"},
+				DecorateStatus: []int{http.StatusOK, http.StatusUnprocessableEntity},
+			},
+			handler: func(w http.ResponseWriter) {
+				http.Error(w, responseBody, http.StatusUnprocessableEntity)
+			},
+			wantCode:        http.StatusUnprocessableEntity,
+			wantAllowOrigin: "http://example.com",
+		},
+		{
+			name: "not decorated",
+			opt: Options{
+				AllowDomain:    []string{"example.com"},
+				DecorateStatus: []int{http.StatusOK, http.StatusUnprocessableEntity},
+			},
+			handler: func(w http.ResponseWriter) {
+				http.Error(w, responseBody, http.StatusForbidden)
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "skip takes precedence",
+			opt: Options{
+				AllowDomain:    []string{"example.com"},
+				DecorateStatus: []int{http.StatusOK},
+				SkipStatus:     []int{http.StatusOK},
+			},
+			handler:  func() string { return responseBody },
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(CORS(test.opt))
+			f.Get("/", test.handler)
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			assert.Nil(t, err)
+			req.Header.Set("Origin", "http://example.com")
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, test.wantCode, resp.Code)
+			assert.Equal(t, test.wantAllowOrigin, resp.Header().Get(HeaderAccessControlAllowOrigin))
+			assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+		})
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(CORS(Options{AllowDomain: []string{"example.com"}, DecorateStatus: []int{http.StatusCreated}}))
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodOptions, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", "http://example.com")
+		req.Header.Set(HeaderAccessControlRequestMethod, http.MethodGet)
+
+		f.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		assert.Equal(t, "http://example.com", resp.Header().Get(HeaderAccessControlAllowOrigin))
+	})
+}
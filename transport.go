@@ -0,0 +1,228 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/flamego/cors/policy"
+)
+
+// BlockedError is returned by Transport when a browser would block the request
+// or its response.
+type BlockedError struct {
+	// URL is the URL of the request.
+	URL string
+	// Reason names the failing check.
+	Reason string
+}
+
+func (err *BlockedError) Error() string {
+	return fmt.Sprintf("CORS request to %s blocked: %s", err.URL, err.Reason)
+}
+
+// safelistedResponseHeaders is the list of CORS-safelisted response headers
+// that are exposed to scripts without "Access-Control-Expose-Headers".
+var safelistedResponseHeaders = []string{
+	"Cache-Control",
+	"Content-Language",
+	"Content-Length",
+	"Content-Type",
+	"Expires",
+	"Last-Modified",
+	"Pragma",
+}
+
+// Transport is an http.RoundTripper that enforces CORS on requests like a
+// browser would for a page served from Origin, for integration tests and tools
+// that verify whether a browser would allow requests to real servers. It sends
+// a preflight request when needed, and returns a *BlockedError when the
+// preflight or the response is not allowed. Response headers that are not
+// exposed to scripts are removed.
+type Transport struct {
+	// Origin is the origin of the simulated page, e.g. "https://example.com".
+	Origin string
+	// Credentials set to true simulates requests with credentials, i.e. the
+	// "include" credentials mode of fetch.
+	Credentials bool
+	// Base is the underlying transport. Default is http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sameOrigin(t.Origin, req.URL) {
+		return t.base().RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderOrigin, t.Origin)
+
+	if names := unsafeHeaderNames(req.Header); !isSimpleMethod(req.Method) || len(names) > 0 {
+		err := t.preflight(req, names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if reason := t.checkOrigin(resp.Header); reason != "" {
+		_ = resp.Body.Close()
+		return nil, &BlockedError{URL: req.URL.String(), Reason: reason}
+	}
+	filterExposedHeaders(resp.Header, t.Credentials)
+	return resp, nil
+}
+
+// preflight sends the preflight request for the request with the unsafe header
+// names.
+func (t *Transport) preflight(req *http.Request, names []string) error {
+	preq, err := http.NewRequestWithContext(req.Context(), http.MethodOptions, req.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+	preq.Header.Set(HeaderOrigin, t.Origin)
+	preq.Header.Set(HeaderAccessControlRequestMethod, req.Method)
+	if len(names) > 0 {
+		preq.Header.Set(HeaderAccessControlRequestHeaders, strings.Join(names, ","))
+	}
+
+	resp, err := t.base().RoundTrip(preq)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	blocked := func(reason string) error {
+		return &BlockedError{URL: req.URL.String(), Reason: "preflight: " + reason}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return blocked(fmt.Sprintf("status code %d is not ok", resp.StatusCode))
+	}
+	if reason := t.checkOrigin(resp.Header); reason != "" {
+		return blocked(reason)
+	}
+
+	methods := policy.HeaderNames(resp.Header.Values(HeaderAccessControlAllowMethods)...)
+	if !isSimpleMethod(req.Method) &&
+		!contains(methods, req.Method) &&
+		(t.Credentials || !contains(methods, "*")) {
+		return blocked(fmt.Sprintf("method %s is not allowed", req.Method))
+	}
+
+	headers := policy.HeaderNames(resp.Header.Values(HeaderAccessControlAllowHeaders)...)
+	for _, name := range names {
+		if policy.HasHeader(headers, name) {
+			continue
+		}
+		// The wildcard never covers "Authorization"
+		if !t.Credentials && contains(headers, "*") && name != "authorization" {
+			continue
+		}
+		return blocked(fmt.Sprintf("header %s is not allowed", name))
+	}
+	return nil
+}
+
+// checkOrigin returns the reason if the response header does not allow the
+// origin to read the response.
+func (t *Transport) checkOrigin(header http.Header) string {
+	allowOrigin := header.Values(HeaderAccessControlAllowOrigin)
+	switch {
+	case len(allowOrigin) == 0:
+		return "no Access-Control-Allow-Origin header"
+	case len(allowOrigin) > 1:
+		return "multiple Access-Control-Allow-Origin headers"
+	case allowOrigin[0] == "*" && t.Credentials:
+		return "wildcard Access-Control-Allow-Origin with credentials"
+	case allowOrigin[0] != "*" && allowOrigin[0] != t.Origin:
+		return fmt.Sprintf("Access-Control-Allow-Origin %s does not match origin %s", allowOrigin[0], t.Origin)
+	case t.Credentials && header.Get(HeaderAccessControlAllowCredentials) != "true":
+		return "Access-Control-Allow-Credentials is not true"
+	}
+	return ""
+}
+
+// sameOrigin returns true if the URL has the same scheme and host as the
+// origin.
+func sameOrigin(origin string, u *url.URL) bool {
+	o, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(o.Scheme, u.Scheme) && strings.EqualFold(o.Host, u.Host)
+}
+
+// isSimpleMethod returns true if the method does not need a preflight.
+func isSimpleMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+// unsafeHeaderNames returns the sorted lowercase names of request headers that
+// are not CORS-safelisted, which need a preflight.
+func unsafeHeaderNames(header http.Header) []string {
+	var names []string
+	for k, v := range header {
+		if k == HeaderOrigin || isSafelistedHeader(k, v) {
+			continue
+		}
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isSafelistedHeader returns true if the request header is CORS-safelisted.
+func isSafelistedHeader(name string, values []string) bool {
+	if !policy.HasHeader(SafelistedHeaders, name) {
+		return false
+	}
+	if !strings.EqualFold(name, "Content-Type") {
+		return true
+	}
+	for _, v := range values {
+		mediaType, _, err := mime.ParseMediaType(v)
+		if err != nil {
+			return false
+		}
+		switch mediaType {
+		case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// filterExposedHeaders removes response headers that are not exposed to
+// scripts.
+func filterExposedHeaders(header http.Header, credentials bool) {
+	exposed := policy.HeaderNames(header.Values(HeaderAccessControlExposeHeaders)...)
+	if !credentials && contains(exposed, "*") {
+		return
+	}
+	for k := range header {
+		if !policy.HasHeader(safelistedResponseHeaders, k) && !policy.HasHeader(exposed, k) {
+			delete(header, k)
+		}
+	}
+}
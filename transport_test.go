@@ -0,0 +1,169 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flamego/flamego"
+)
+
+func TestTransport(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:      []string{"example.com"},
+		Methods:          []string{http.MethodGet, http.MethodPost, http.MethodPut},
+		AllowHeaders:     []string{"X-Token"},
+		AllowCredentials: true,
+		ExposeTrailers:   []string{"X-Exposed"},
+	}))
+	f.Any("/", func(c flamego.Context) string {
+		c.ResponseWriter().Header().Set("X-Exposed", "1")
+		c.ResponseWriter().Header().Set("X-Hidden", "1")
+		return responseBody
+	})
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		origin      string
+		credentials bool
+		method      string
+		header      map[string]string
+		wantErr     string
+	}{
+		{
+			name:   "simple request",
+			origin: "http://example.com",
+			method: http.MethodGet,
+		},
+		{
+			name:        "simple request with credentials",
+			origin:      "http://example.com",
+			credentials: true,
+			method:      http.MethodPost,
+			header:      map[string]string{"Content-Type": "text/plain"},
+		},
+		{
+			name:   "preflight for method",
+			origin: "http://example.com",
+			method: http.MethodPut,
+		},
+		{
+			name:   "preflight for header",
+			origin: "http://example.com",
+			method: http.MethodPost,
+			header: map[string]string{"Content-Type": "application/json", "X-Token": "secret"},
+		},
+		{
+			name:    "method not allowed",
+			origin:  "http://example.com",
+			method:  http.MethodDelete,
+			wantErr: "CORS request to " + server.URL + "/ blocked: preflight: method DELETE is not allowed",
+		},
+		{
+			name:    "header not allowed",
+			origin:  "http://example.com",
+			method:  http.MethodGet,
+			header:  map[string]string{"X-Other": "1"},
+			wantErr: "CORS request to " + server.URL + "/ blocked: preflight: status code 400 is not ok",
+		},
+		{
+			name:    "prohibited origin",
+			origin:  "http://evil.com",
+			method:  http.MethodGet,
+			wantErr: "CORS request to " + server.URL + "/ blocked: no Access-Control-Allow-Origin header",
+		},
+		{
+			name:   "same origin",
+			origin: server.URL,
+			method: http.MethodDelete,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &Transport{
+					Origin:      test.origin,
+					Credentials: test.credentials,
+				},
+			}
+			req, err := http.NewRequest(test.method, server.URL+"/", nil)
+			assert.Nil(t, err)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := client.Do(req)
+			if test.wantErr != "" {
+				var blocked *BlockedError
+				if assert.True(t, errors.As(err, &blocked)) {
+					assert.Equal(t, test.wantErr, blocked.Error())
+				}
+				return
+			}
+			assert.Nil(t, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			body, err := io.ReadAll(resp.Body)
+			assert.Nil(t, err)
+			assert.Equal(t, responseBody, string(body))
+		})
+	}
+}
+
+func TestTransport_WildcardWithCredentials(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS())
+	f.Get("/", func() string {
+		return responseBody
+	})
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &Transport{
+			Origin:      "http://example.com",
+			Credentials: true,
+		},
+	}
+	_, err := client.Get(server.URL)
+	var blocked *BlockedError
+	if assert.True(t, errors.As(err, &blocked)) {
+		assert.Equal(t, "wildcard Access-Control-Allow-Origin with credentials", blocked.Reason)
+	}
+}
+
+func TestTransport_ExposedHeaders(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		AllowDomain:    []string{"example.com"},
+		ExposeTrailers: []string{"X-Exposed"},
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		c.ResponseWriter().Header().Set("X-Exposed", "1")
+		c.ResponseWriter().Header().Set("X-Hidden", "1")
+		return responseBody
+	})
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{Origin: "http://example.com"}}
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, "1", resp.Header.Get("X-Exposed"))
+	assert.Empty(t, resp.Header.Get("X-Hidden"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TXTResolver looks up DNS TXT records, it is implemented by *net.Resolver.
+type TXTResolver interface {
+	// LookupTXT returns the DNS TXT records for the given domain name.
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var defaultResolver TXTResolver = net.DefaultResolver
+
+// TXTAllowlist looks up allowed origins from a DNS TXT record, so that the
+// allowlist can be managed via DNS that the team already controls. Every
+// record of the name holds origins separated by spaces or commas, e.g.
+// "https://example.com https://app.example.com". It is used by setting
+// Options.TXTAllowlist, and is safe for concurrent use.
+type TXTAllowlist struct {
+	// Name is the domain name of the TXT record, e.g. "_cors.example.com".
+	Name string
+	// Resolver is the resolver to look up the record with. Default is
+	// net.DefaultResolver.
+	Resolver TXTResolver
+	// TTL is how long the origins of a successful lookup are cached. Default is
+	// 5 minutes.
+	TTL time.Duration
+	// Clock returns the current time. Default is time.Now, or Options.Clock
+	// when used by the middleware.
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	origins []string
+	expires time.Time
+}
+
+// Lookup returns the allowed origins of the TXT record, which are cached for
+// the TTL. It returns an error if the record holds malformed origins or no
+// origins at all.
+func (l *TXTAllowlist) Lookup(ctx context.Context) ([]string, error) {
+	now := time.Now()
+	if l.Clock != nil {
+		now = l.Clock()
+	}
+	return l.lookup(ctx, now)
+}
+
+// lookup returns the allowed origins of the TXT record at the given time. On
+// errors, the origins of the last successful lookup are returned as well.
+func (l *TXTAllowlist) lookup(ctx context.Context, now time.Time) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.origins != nil && now.Before(l.expires) {
+		return l.origins, nil
+	}
+
+	resolver := l.Resolver
+	if resolver == nil {
+		resolver = defaultResolver
+	}
+	records, err := resolver.LookupTXT(ctx, l.Name)
+	if err != nil {
+		return l.origins, errors.Wrap(err, "lookup TXT")
+	}
+	origins, err := parseTXTOrigins(records)
+	if err != nil {
+		return l.origins, errors.Wrapf(err, "parse TXT record %s", l.Name)
+	}
+
+	ttl := l.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	l.origins = origins
+	l.expires = now.Add(ttl)
+	return origins, nil
+}
+
+// allowed returns true if the origin is allowed by the TXT record at the given
+// time. The origins of the last successful lookup are used while lookups fail,
+// and every origin is denied until one succeeds.
+func (l *TXTAllowlist) allowed(ctx context.Context, origin string, now time.Time) bool {
+	origins, _ := l.lookup(ctx, now)
+	for _, o := range origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTXTOrigins returns the origins of the TXT records, which must be
+// serialized origins such as "https://example.com".
+func parseTXTOrigins(records []string) ([]string, error) {
+	origins := []string{}
+	for _, r := range records {
+		for _, o := range strings.FieldsFunc(r, func(c rune) bool { return c == ' ' || c == ',' }) {
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" || u.User != nil ||
+				(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+				return nil, errors.Errorf("invalid origin %q", o)
+			}
+			origin := strings.ToLower(u.Scheme + "://" + u.Host)
+			if !contains(origins, origin) {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	if len(origins) == 0 {
+		return nil, errors.New("no origins")
+	}
+	return origins, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cors
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flamego/flamego"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTXTResolver struct {
+	records map[string][]string
+	lookups int
+}
+
+func (r *mockTXTResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	r.lookups++
+	records, ok := r.records[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func TestTXTAllowlist_Lookup(t *testing.T) {
+	newResolver := func() *mockTXTResolver {
+		return &mockTXTResolver{
+			records: map[string][]string{
+				"_cors.example.com": {"https://example.com https://App.example.com", "http://localhost:3000,https://example.com"},
+				"_cors.example.net": {" , "},
+				"_cors.example.io":  {"https://example.com example.com"},
+			},
+		}
+	}
+
+	t.Run("origins", func(t *testing.T) {
+		l := &TXTAllowlist{Name: "_cors.example.com", Resolver: newResolver()}
+		got, err := l.Lookup(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com", "https://app.example.com", "http://localhost:3000"}, got)
+	})
+
+	t.Run("cached for the TTL", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		resolver := newResolver()
+		l := &TXTAllowlist{
+			Name:     "_cors.example.com",
+			Resolver: resolver,
+			TTL:      time.Minute,
+			Clock:    func() time.Time { return now },
+		}
+		for i := 0; i < 3; i++ {
+			_, err := l.Lookup(context.Background())
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, 1, resolver.lookups)
+
+		now = now.Add(time.Minute)
+		_, err := l.Lookup(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, 2, resolver.lookups)
+	})
+
+	t.Run("no origins", func(t *testing.T) {
+		l := &TXTAllowlist{Name: "_cors.example.net", Resolver: newResolver()}
+		_, err := l.Lookup(context.Background())
+		assert.EqualError(t, err, "parse TXT record _cors.example.net: no origins")
+	})
+
+	t.Run("malformed origin", func(t *testing.T) {
+		l := &TXTAllowlist{Name: "_cors.example.io", Resolver: newResolver()}
+		_, err := l.Lookup(context.Background())
+		assert.EqualError(t, err, `parse TXT record _cors.example.io: invalid origin "example.com"`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		l := &TXTAllowlist{Name: "_cors.example.org", Resolver: newResolver()}
+		_, err := l.Lookup(context.Background())
+		assert.EqualError(t, err, "lookup TXT: no such host")
+	})
+}
+
+func TestTXTAllowlist_Options(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	resolver := &mockTXTResolver{
+		records: map[string][]string{
+			"_cors.example.com": {"https://example.com"},
+		},
+	}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(CORS(Options{
+		TXTAllowlist: &TXTAllowlist{Name: "_cors.example.com", Resolver: resolver, TTL: time.Minute},
+		Clock:        func() time.Time { return now },
+	}))
+	f.Get("/", func(c flamego.Context) string {
+		return responseBody
+	})
+
+	serve := func(origin string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Origin", origin)
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := serve("https://example.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "https://example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.StatusBadRequest, serve("https://evil.com").Code)
+	assert.Equal(t, 1, resolver.lookups)
+
+	// The record changes, which is picked up once the TTL has passed
+	resolver.records["_cors.example.com"] = []string{"https://app.example.com"}
+	assert.Equal(t, http.StatusOK, serve("https://example.com").Code)
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusBadRequest, serve("https://example.com").Code)
+	assert.Equal(t, http.StatusOK, serve("https://app.example.com").Code)
+	assert.Equal(t, 2, resolver.lookups)
+
+	// Lookups that fail keep the last known origins
+	delete(resolver.records, "_cors.example.com")
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, serve("https://app.example.com").Code)
+}